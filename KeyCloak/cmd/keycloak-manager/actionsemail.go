@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Set once the realm turns out to be unable to send e-mail, so no further triggers are attempted
+var actionsEmailDisabled atomic.Bool
+
+// Trigger the execute-actions e-mail for a created user, if -actions-email is configured
+func sendActionsEmail(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, userID, userName string) {
+	if len(config.actionsEmail) == 0 || actionsEmailDisabled.Load() {
+		return
+	}
+
+	params := gocloak.ExecuteActionsEmail{
+		UserID:   &userID,
+		Actions:  &config.actionsEmail,
+		Lifespan: gocloak.IntP(int(config.actionsLifespan.Seconds())),
+	}
+
+	startTime := time.Now()
+	err := client.ExecuteActionsEmail(ctx, token.AccessToken, realm, params)
+	latency := time.Since(startTime)
+
+	// Update latency metrics
+	updateLatencyMetrics(opActionsEmail, latency)
+
+	if err != nil {
+		updateErrorMetrics(keycloakload.StatusFromError(err))
+		recordFailure(opActionsEmail, userName, err)
+		if isSMTPError(err) {
+			slog.Warn("Realm failed to send an actions e-mail, is SMTP configured? Not sending any more", "realm", realm, "user", userName, "err", err)
+			actionsEmailDisabled.Store(true)
+			return
+		}
+		slog.Error("Failed to send actions e-mail", "user", userName, "err", err)
+		return
+	}
+	slog.Debug("Sent actions e-mail", "actions", config.actionsEmail, "user", userName)
+}
+
+// Keycloak answers a 500 "Failed to send execute actions email" when it can't reach an SMTP server
+func isSMTPError(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "failed to send")
+}
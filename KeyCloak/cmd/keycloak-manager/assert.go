@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Expected realm structure for -assert-spec
+type Spec struct {
+	Groups []SpecGroup `json:"groups"`
+	Users  []SpecUser  `json:"users"`
+}
+
+type SpecGroup struct {
+	Name       string              `json:"name"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+	RealmRoles []string            `json:"realmRoles,omitempty"`
+	SubGroups  []SpecGroup         `json:"subGroups,omitempty"`
+}
+
+type SpecUser struct {
+	Username   string              `json:"username"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+	RealmRoles []string            `json:"realmRoles,omitempty"`
+	// Group paths, e.g. "/Group-1/Group-1-subgroup-1"
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Result of checking the realm against a spec
+type specAssertion struct {
+	checked       int
+	discrepancies []string
+
+	token          *gocloak.JWT
+	expirationTime time.Time
+}
+
+// Admin token for checking the next entity, refreshed so that a large spec can outlast its lifespan
+func (a *specAssertion) validToken(ctx context.Context, client *gocloak.GoCloak) *gocloak.JWT {
+	a.token, a.expirationTime = ensureValidToken(ctx, client, a.token, a.expirationTime)
+	return a.token
+}
+
+func (a *specAssertion) fail(format string, args ...any) {
+	a.discrepancies = append(a.discrepancies, fmt.Sprintf(format, args...))
+}
+
+// Check that every group, subgroup and user in the spec file exists in the realm with its
+// attributes, role mappings and memberships, that the spec groups have no other subgroups or
+// members, and report the first discrepancies. Returns an error if the realm doesn't match.
+func assertSpec(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var spec Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	// Memberships of these are checked by user, members of a spec group that aren't one are unexpected
+	specUsers := make(map[string]bool)
+	for _, user := range spec.Users {
+		specUsers[user.Username] = true
+	}
+
+	assertion := specAssertion{token: token, expirationTime: expirationTime}
+	for _, group := range spec.Groups {
+		assertGroup(ctx, client, realm, &assertion, "", group, specUsers)
+	}
+	for _, user := range spec.Users {
+		assertUser(ctx, client, realm, &assertion, user)
+	}
+
+	return assertion.report("Spec assertion", path)
+}
+
+// Log the first -assert-max-discrepancies discrepancies, returning an error if there are any
+func (a *specAssertion) report(kind, path string) error {
+	summaryLog.Printf("%s: %d entities checked, %d discrepancies", kind, a.checked, len(a.discrepancies))
+	for i, discrepancy := range a.discrepancies {
+		if i == config.assertMaxDiscrepancies {
+			slog.Error("More discrepancies not shown", "count", len(a.discrepancies)-i)
+			break
+		}
+		slog.Error("FAIL " + discrepancy)
+	}
+	if len(a.discrepancies) > 0 {
+		return fmt.Errorf("realm doesn't match %s", path)
+	}
+	summaryLog.Printf("PASS realm matches %s", path)
+	return nil
+}
+
+func assertGroup(ctx context.Context, client *gocloak.GoCloak, realm string, assertion *specAssertion,
+	parentPath string, expected SpecGroup, specUsers map[string]bool) {
+	path := parentPath + keycloakload.GroupPath(expected.Name)
+	assertion.checked++
+	token := assertion.validToken(ctx, client)
+
+	group, err := client.GetGroupByPath(ctx, token.AccessToken, realm, keycloakload.GroupPathURL(path))
+	if err != nil {
+		if keycloakload.IsNotFound(err) {
+			assertion.fail("group %s missing", path)
+		} else {
+			assertion.fail("group %s: failed to get: %v", path, err)
+		}
+		// Subgroups of a missing group are missing too, reporting them adds nothing
+		return
+	}
+
+	assertAttributes(assertion, "group "+path, group.Attributes, expected.Attributes)
+
+	if len(expected.RealmRoles) > 0 {
+		roles, err := client.GetRealmRolesByGroupID(ctx, token.AccessToken, realm, *group.ID)
+		if err != nil {
+			assertion.fail("group %s: failed to get realm roles: %v", path, err)
+		} else {
+			assertRoles(assertion, "group "+path, roles, expected.RealmRoles)
+		}
+	}
+
+	assertSubgroups(ctx, client, realm, assertion, path, group, expected.SubGroups)
+	assertMembers(ctx, client, realm, assertion, path, *group.ID, specUsers)
+
+	for _, subGroup := range expected.SubGroups {
+		assertGroup(ctx, client, realm, assertion, path, subGroup, specUsers)
+	}
+}
+
+// Report subgroups of the group that the spec doesn't list. Keycloak 23 and later no longer embed
+// subgroups in the group representation, so those are listed through the children endpoint.
+func assertSubgroups(ctx context.Context, client *gocloak.GoCloak, realm string, assertion *specAssertion,
+	path string, group *gocloak.Group, expected []SpecGroup) {
+	var expectedNames []string
+	for _, subGroup := range expected {
+		expectedNames = append(expectedNames, subGroup.Name)
+	}
+	assertNames := func(children []gocloak.Group) {
+		for _, child := range children {
+			if !slices.Contains(expectedNames, *child.Name) {
+				assertion.fail("group %s: unexpected subgroup %s", path, *child.Name)
+			}
+		}
+	}
+
+	if group.SubGroups != nil && len(*group.SubGroups) > 0 {
+		assertNames(*group.SubGroups)
+		return
+	}
+	for first := 0; ; first += usersPageSize {
+		children, err := getChildGroups(ctx, client, assertion.validToken(ctx, client), realm, *group.ID, first, usersPageSize)
+		if err != nil {
+			assertion.fail("group %s: failed to get subgroups: %v", path, err)
+			return
+		}
+		assertNames(children)
+		if len(children) < usersPageSize {
+			return
+		}
+	}
+}
+
+// Report members of the group that aren't users of the spec
+func assertMembers(ctx context.Context, client *gocloak.GoCloak, realm string, assertion *specAssertion,
+	path, groupID string, specUsers map[string]bool) {
+	for first := 0; ; first += usersPageSize {
+		users, err := client.GetGroupMembers(ctx, assertion.validToken(ctx, client).AccessToken, realm, groupID, gocloak.GetGroupsParams{
+			BriefRepresentation: gocloak.BoolP(true),
+			First:               gocloak.IntP(first),
+			Max:                 gocloak.IntP(usersPageSize),
+		})
+		if err != nil {
+			assertion.fail("group %s: failed to get members: %v", path, err)
+			return
+		}
+		for _, user := range users {
+			if !specUsers[*user.Username] {
+				assertion.fail("group %s: unexpected member %s", path, *user.Username)
+			}
+		}
+		if len(users) < usersPageSize {
+			return
+		}
+	}
+}
+
+func assertUser(ctx context.Context, client *gocloak.GoCloak, realm string, assertion *specAssertion, expected SpecUser) {
+	assertion.checked++
+	token := assertion.validToken(ctx, client)
+
+	users, err := client.GetUsers(ctx, token.AccessToken, realm, gocloak.GetUsersParams{
+		Username: &expected.Username,
+		Exact:    gocloak.BoolP(true),
+	})
+	if err != nil {
+		assertion.fail("user %s: failed to get: %v", expected.Username, err)
+		return
+	}
+	if len(users) == 0 {
+		assertion.fail("user %s missing", expected.Username)
+		return
+	}
+	user := users[0]
+	entity := "user " + expected.Username
+
+	assertAttributes(assertion, entity, user.Attributes, expected.Attributes)
+
+	if len(expected.RealmRoles) > 0 {
+		roles, err := client.GetRealmRolesByUserID(ctx, token.AccessToken, realm, *user.ID)
+		if err != nil {
+			assertion.fail("%s: failed to get realm roles: %v", entity, err)
+		} else {
+			assertRoles(assertion, entity, roles, expected.RealmRoles)
+		}
+	}
+
+	// Memberships are checked both ways, a user the spec lists in no group must be in none
+	groups, err := client.GetUserGroups(ctx, token.AccessToken, realm, *user.ID, gocloak.GetGroupsParams{})
+	if err != nil {
+		assertion.fail("%s: failed to get groups: %v", entity, err)
+		return
+	}
+	var paths []string
+	for _, group := range groups {
+		paths = append(paths, *group.Path)
+	}
+	for _, path := range expected.Groups {
+		if !slices.Contains(paths, path) {
+			assertion.fail("%s: not a member of %s", entity, path)
+		}
+	}
+	for _, path := range paths {
+		if !slices.Contains(expected.Groups, path) {
+			assertion.fail("%s: unexpected member of %s", entity, path)
+		}
+	}
+}
+
+// Check the expected attributes, other attributes may be present
+func assertAttributes(assertion *specAssertion, entity string, actual *map[string][]string, expected map[string][]string) {
+	for name, values := range expected {
+		var actualValues []string
+		if actual != nil {
+			actualValues = (*actual)[name]
+		}
+		if !reflect.DeepEqual(actualValues, values) {
+			assertion.fail("%s: attribute %s is %v, expected %v", entity, name, actualValues, values)
+		}
+	}
+}
+
+func assertRoles(assertion *specAssertion, entity string, roles []*gocloak.Role, expected []string) {
+	var names []string
+	for _, role := range roles {
+		names = append(names, *role.Name)
+	}
+	for _, name := range expected {
+		if !slices.Contains(names, name) {
+			assertion.fail("%s: realm role %s not mapped", entity, name)
+		}
+	}
+}
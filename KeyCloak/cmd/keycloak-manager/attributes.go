@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Parse an -attribute-value-size, a size or a min-max range of sizes
+func parseSizeRange(value string) (int, int, error) {
+	minValue, maxValue, isRange := strings.Cut(value, "-")
+	lowest, err := strconv.Atoi(minValue)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid size %q", value)
+	}
+	if !isRange {
+		return lowest, lowest, nil
+	}
+	highest, err := strconv.Atoi(maxValue)
+	if err != nil || highest < lowest {
+		return 0, 0, fmt.Errorf("invalid size range %q", value)
+	}
+	return lowest, highest, nil
+}
+
+// Print latency by attribute payload
+func printAttributeMetrics(snapshot keycloakload.MetricsSnapshot) {
+	keys := make([]keycloakload.AttributeKey, 0, len(snapshot.Attributes))
+	for key := range snapshot.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Bucket != keys[j].Bucket {
+			return keys[i].Bucket < keys[j].Bucket
+		}
+		return keys[i].Op < keys[j].Op
+	})
+	for _, key := range keys {
+		stats := snapshot.Attributes[key]
+		summaryLog.Printf("Attributes %s %s: count=%d avg=%v p95=%v p99=%v", keycloakload.AttributeBucketName(key.Bucket), key.Op,
+			stats.Count, stats.Mean, stats.P95, stats.P99)
+	}
+}
+
+// Read a created user back with its attributes, so that GetUsers is measured against the payload too
+func readBackAttributes(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, userName string, size int) {
+	err := runner.Retry(ctx, opGetUsers, func() error {
+		if err := runner.WaitForRate(ctx); err != nil {
+			return err
+		}
+		startTime := time.Now()
+		_, err := client.GetUsers(ctx, token.AccessToken, realm, gocloak.GetUsersParams{
+			Username:            &userName,
+			Exact:               gocloak.BoolP(true),
+			BriefRepresentation: gocloak.BoolP(false),
+		})
+		latency := time.Since(startTime)
+
+		updateLatencyMetrics(opGetUsers, latency)
+		loadMetrics.RecordAttributeLatency(size, opGetUsers, latency)
+		return err
+	})
+	if err != nil && !errors.Is(err, keycloakload.ErrStopped) {
+		slog.Error("Failed to read back user", "user", userName, "err", err)
+		updateOperationErrorMetrics(opGetUsers, keycloakload.StatusFromError(err))
+		recordFailure(opGetUsers, userName, err)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestParseSizeRange(t *testing.T) {
+	if lowest, highest, err := parseSizeRange("64"); err != nil || lowest != 64 || highest != 64 {
+		t.Errorf("parseSizeRange(64) = %d, %d, %v", lowest, highest, err)
+	}
+	if lowest, highest, err := parseSizeRange("16-4096"); err != nil || lowest != 16 || highest != 4096 {
+		t.Errorf("parseSizeRange(16-4096) = %d, %d, %v", lowest, highest, err)
+	}
+	for _, value := range []string{"", "big", "10-5", "1-x"} {
+		if _, _, err := parseSizeRange(value); err == nil {
+			t.Errorf("parseSizeRange(%q) succeeded", value)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Client the admin user logs in through
+const adminClientID = "admin-cli"
+
+// Whether to log in with the client credentials grant of -client-id, for realms that forbid password grants
+func usingClientCredentials() bool {
+	return config.clientID != ""
+}
+
+// Grant of the admin token: the service account of -client-id when set, otherwise the admin user
+func adminGrant(client *gocloak.GoCloak) keycloakload.TokenSource {
+	if usingClientCredentials() {
+		return keycloakload.ClientCredentialsGrant(client, config.clientID, config.clientSecret, config.realm)
+	}
+	return keycloakload.PasswordGrant(client, adminClientID, config.realm, config.adminUser, config.adminPassword)
+}
+
+func login(ctx context.Context, client *gocloak.GoCloak) (*gocloak.JWT, error) {
+	token, _, err := adminGrant(client)(ctx, nil, time.Time{})
+	return token, err
+}
+
+// Refresh a token obtained by login, logging in again when it can't be refreshed.
+// Client credentials tokens usually come without a refresh token.
+func refreshLogin(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT) (*gocloak.JWT, error) {
+	newToken, _, err := adminGrant(client)(ctx, token, time.Time{})
+	return newToken, err
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Deletions done and failed by -cleanup
+type cleanupSummary struct {
+	usersDeleted, usersFailed   int
+	groupsDeleted, groupsFailed int
+}
+
+// Delete everything recorded in an -output file, users first, then subgroups, then their groups.
+// Reused groups and subgroups existed before the run and are kept.
+func cleanup(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time, path string) error {
+	// Requests in flight finish after a shutdown signal, which is only checked between steps
+	shutdown, ctx := ctx, context.WithoutCancel(ctx)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var recorded Registry
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if recorded.Realm != "" && recorded.Realm != realm {
+		return fmt.Errorf("%s was recorded in realm %s, not %s", path, recorded.Realm, realm)
+	}
+
+	total := len(recorded.Users)
+	for _, group := range recorded.Groups {
+		for _, subGroup := range group.SubGroups {
+			total += recordedDeletions(subGroup)
+		}
+		if !group.Reused {
+			total++
+		}
+	}
+	startProgress(total)
+
+	var summary cleanupSummary
+
+	// Nested subgroups go before their parent, returns false on a shutdown signal
+	var deleteSubgroup func(realm string, subGroup *RegistrySubgroup) bool
+	deleteSubgroup = func(realm string, subGroup *RegistrySubgroup) bool {
+		for _, child := range subGroup.SubGroups {
+			if !deleteSubgroup(realm, child) {
+				return false
+			}
+		}
+		for _, user := range subGroup.Users {
+			if shutdown.Err() != nil {
+				return false
+			}
+			token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+			deleteRecorded(opDeleteUser, "user", user.Name, &summary.usersDeleted, &summary.usersFailed, func() error {
+				return client.DeleteUser(ctx, token.AccessToken, realm, user.ID)
+			})
+		}
+		if !subGroup.Reused {
+			token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+			deleteRecorded(opDeleteGroup, "subgroup", subGroup.Name, &summary.groupsDeleted, &summary.groupsFailed, func() error {
+				return client.DeleteGroup(ctx, token.AccessToken, realm, subGroup.ID)
+			})
+		}
+		return true
+	}
+
+	for _, user := range recorded.Users {
+		if shutdown.Err() != nil {
+			return nil
+		}
+		token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+		deleteRecorded(opDeleteUser, "user", user.Name, &summary.usersDeleted, &summary.usersFailed, func() error {
+			return client.DeleteUser(ctx, token.AccessToken, realm, user.ID)
+		})
+	}
+
+	for _, group := range recorded.Groups {
+		realm := realm
+		if group.Realm != "" {
+			realm = group.Realm
+		}
+		for _, subGroup := range group.SubGroups {
+			if !deleteSubgroup(realm, subGroup) {
+				return nil
+			}
+		}
+		if !group.Reused {
+			token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+			deleteRecorded(opDeleteGroup, "group", group.Name, &summary.groupsDeleted, &summary.groupsFailed, func() error {
+				return client.DeleteGroup(ctx, token.AccessToken, realm, group.ID)
+			})
+		}
+	}
+
+	summary.log()
+	return nil
+}
+
+// Delete every generated user and top-level group in the realm under the current -prefix, for
+// realms that were filled without an -output file. Subgroups go with their group.
+func cleanupGenerated(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time) error {
+	// Requests in flight finish after a shutdown signal, which is only checked between steps
+	shutdown, ctx := ctx, context.WithoutCancel(ctx)
+
+	// Everything is listed before deleting, so deletions don't shift the pages
+	users, err := listGeneratedUsers(ctx, client, token, realm, 0)
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+	groups, err := listGeneratedGroups(ctx, client, token, realm)
+	if err != nil {
+		return fmt.Errorf("failed to list groups: %w", err)
+	}
+	slog.Info("Found generated entities", "users", len(users), "groups", len(groups))
+	startProgress(len(users) + len(groups))
+
+	var summary cleanupSummary
+	for _, user := range users {
+		if shutdown.Err() != nil {
+			return nil
+		}
+		token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+		deleteRecorded(opDeleteUser, "user", *user.Username, &summary.usersDeleted, &summary.usersFailed, func() error {
+			return client.DeleteUser(ctx, token.AccessToken, realm, *user.ID)
+		})
+	}
+	for _, group := range groups {
+		if shutdown.Err() != nil {
+			return nil
+		}
+		token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+		deleteRecorded(opDeleteGroup, "group", *group.Name, &summary.groupsDeleted, &summary.groupsFailed, func() error {
+			return client.DeleteGroup(ctx, token.AccessToken, realm, *group.ID)
+		})
+	}
+
+	summary.log()
+	return nil
+}
+
+// Number of deletions recorded in a subgroup and below it
+func recordedDeletions(subGroup *RegistrySubgroup) int {
+	deletions := len(subGroup.Users)
+	if !subGroup.Reused {
+		deletions++
+	}
+	for _, child := range subGroup.SubGroups {
+		deletions += recordedDeletions(child)
+	}
+	return deletions
+}
+
+func (s cleanupSummary) log() {
+	if config.dryRun {
+		summaryLog.Printf("Cleanup dry run: would delete %d users and %d groups", s.usersDeleted, s.groupsDeleted)
+		return
+	}
+	summaryLog.Printf("Cleanup: deleted %d users (%d failed) and %d groups (%d failed)",
+		s.usersDeleted, s.usersFailed, s.groupsDeleted, s.groupsFailed)
+}
+
+// Name prefixes of the users the modes generate: subgroup users, -population and -register-users
+var generatedUserPrefixes = []string{"User-", populationPrefix, registeredPrefix}
+
+// Users the generator marked under the current -prefix, whatever -user-data or -username-template
+// named them, then the users with generated names that carry no marker, e.g. -register-users ones
+// or ones in realms that drop unmanaged attributes. At most limit of them unless it is 0.
+func listGeneratedUsers(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, limit int) ([]*gocloak.User, error) {
+	var generated []*gocloak.User
+	listed := make(map[string]bool)
+	add := func(user *gocloak.User) bool {
+		if !listed[*user.ID] {
+			listed[*user.ID] = true
+			generated = append(generated, user)
+		}
+		return limit > 0 && len(generated) == limit
+	}
+
+	marker := keycloakload.GeneratedAttribute + ":" + keycloakload.GeneratedMarker(config.prefix)
+	for first := 0; ; first += usersPageSize {
+		users, err := client.GetUsers(ctx, token.AccessToken, realm, gocloak.GetUsersParams{
+			BriefRepresentation: gocloak.BoolP(true),
+			Q:                   &marker,
+			First:               gocloak.IntP(first),
+			Max:                 gocloak.IntP(usersPageSize),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			if add(user) {
+				return generated, nil
+			}
+		}
+		if len(users) < usersPageSize {
+			break
+		}
+	}
+
+	for _, namePrefix := range generatedUserPrefixes {
+		// Keycloak stores usernames in lower case, and -name-collision-strategy suffix may have added a number
+		pattern := regexp.MustCompile(`^(?i)` + regexp.QuoteMeta(config.prefix+namePrefix) + `\d+-\d+(-\d+)?$`)
+
+		for first := 0; ; first += usersPageSize {
+			users, err := client.GetUsers(ctx, token.AccessToken, realm, gocloak.GetUsersParams{
+				BriefRepresentation: gocloak.BoolP(true),
+				Search:              gocloak.StringP(strings.ToLower(config.prefix + namePrefix)),
+				First:               gocloak.IntP(first),
+				Max:                 gocloak.IntP(usersPageSize),
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, user := range users {
+				// Search also matches e-mails and names
+				if user.Username != nil && pattern.MatchString(*user.Username) && add(user) {
+					return generated, nil
+				}
+			}
+			if len(users) < usersPageSize {
+				break
+			}
+		}
+	}
+	return generated, nil
+}
+
+func listGeneratedGroups(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) ([]*gocloak.Group, error) {
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(config.prefix) + `Group-\d+(-\d+)?$`)
+
+	var generated []*gocloak.Group
+	for first := 0; ; first += usersPageSize {
+		groups, err := client.GetGroups(ctx, token.AccessToken, realm, gocloak.GetGroupsParams{
+			BriefRepresentation: gocloak.BoolP(true),
+			Search:              gocloak.StringP(config.prefix + "Group-"),
+			First:               gocloak.IntP(first),
+			Max:                 gocloak.IntP(usersPageSize),
+		})
+		if err != nil {
+			return nil, err
+		}
+		// Search returns top-level groups, with their matching subgroups nested in them
+		for _, group := range groups {
+			if group.Name != nil && pattern.MatchString(*group.Name) {
+				generated = append(generated, group)
+			}
+		}
+		if len(groups) < usersPageSize {
+			return generated, nil
+		}
+	}
+}
+
+// Run one recorded deletion, counting a 404 as already deleted. With -dry-run it is only logged.
+func deleteRecorded(op, kind, name string, deleted, failed *int, del func() error) {
+	defer advanceProgress()
+	if config.dryRun {
+		slog.Debug("Would delete "+kind, "name", name)
+		*deleted++
+		return
+	}
+
+	startTime := time.Now()
+	err := del()
+	latency := time.Since(startTime)
+
+	// Update latency metrics
+	updateLatencyMetrics(op, latency)
+
+	if err != nil && !keycloakload.IsNotFound(err) {
+		slog.Error("Failed to delete "+kind, "name", name, "err", err)
+		updateErrorMetrics(keycloakload.StatusFromError(err))
+		recordFailure(op, name, err)
+		*failed++
+		return
+	}
+	slog.Debug("Deleted "+kind, "name", name)
+	*deleted++
+	if op == opDeleteUser {
+		incrementUserDeletedCounter()
+	}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/joho/godotenv"
+)
+
+// Command line configuration
+type Config struct {
+	configFile        string
+	url               string
+	adminUser         string
+	adminPassword     string
+	adminPasswordFile string
+	clientID          string
+	clientSecret      string
+	clientSecretFile  string
+	realm             string
+
+	// CA bundle trusted on top of the system roots, and client certificate for mutual TLS
+	caFile             string
+	tlsCertFile        string
+	tlsKeyFile         string
+	insecureSkipVerify bool
+
+	// Realms group trees are spread over instead of the login realm
+	realms       []string
+	createRealms bool
+
+	// Levels of the group hierarchy including the top-level groups, users go in the deepest
+	groupDepth int
+	// Subgroups per group by level below the top, overriding -subgroups and -group-depth
+	fanOut []int
+	// Stop once this many users are created, 0 runs until stopped
+	totalUsers int
+
+	// Stop conditions, the error thresholds fail the run
+	duration           time.Duration
+	maxErrors          int
+	errorRateThreshold float64
+
+	subgroups        int
+	usersPerSubgroup int
+	workers          int
+	concurrency      int
+	rate             float64
+	prefix           string
+	maxRetries       int
+	subgroupDelay    time.Duration
+
+	removeMemberships int
+	skipPreflight     bool
+
+	registerUsers        int
+	registrationClient   string
+	registrationPassword string
+
+	hdrOut string
+
+	nameCollisionStrategy string
+	upsert                bool
+
+	summaryOnSignal bool
+
+	// Listen address of the Prometheus /metrics endpoint
+	metricsAddr string
+
+	webhookURL       string
+	webhookQueueSize int
+
+	maintainPopulation int
+	churnInterval      time.Duration
+
+	token        string
+	tokenFile    string
+	refreshToken string
+
+	deterministic bool
+	seed          int64
+
+	actionsEmail    []string
+	actionsLifespan time.Duration
+	emailDomains    []string
+
+	// Data of generated users, one of userDataKinds
+	userData         string
+	usernameTemplate string
+	emailTemplate    string
+	locales          []string
+	// Custom attributes of generated users, see -attributes
+	attributes keycloakload.AttributeConfig
+
+	failuresOut string
+	failuresMax int
+
+	groupSizeHistogram string
+
+	groupRoles bool
+
+	verifyGroupCount bool
+
+	rawLatencyOut string
+
+	measureOps []string
+
+	recordOut string
+	replay    string
+
+	groupDepthLatency bool
+
+	federatedUsers string
+	// CSV or JSON file of users to create instead of generated ones
+	importFile string
+
+	outageThreshold time.Duration
+
+	progressInterval time.Duration
+
+	assertSpec             string
+	assertMaxDiscrepancies int
+
+	userPassword      string
+	randomPasswords   bool
+	temporaryPassword bool
+	requiredActions   []string
+
+	// Roles given to created users, each to its weighted share of them
+	userRoles []*userRoleShare
+
+	// Operations of a mixed workload by weight, run instead of creating group trees
+	scenario []scenarioShare
+
+	// End-of-run report for comparing runs, JSON or CSV by extension
+	report string
+
+	logLevel        slog.Level
+	logFormat       string
+	logSummaryOnly  bool
+	summaryInterval time.Duration
+	// JSON lines of every record down to debug level, like one per created entity
+	requestLog string
+
+	output  string
+	resume  bool
+	cleanup string
+	verify  string
+	// Delete whatever is named like generated groups and users instead of what an -output file recorded
+	cleanupGenerated bool
+	dryRun           bool
+}
+
+var config Config
+
+// A flag parsed by a function like flag.Func, which also reports its value, e.g. for -report
+type funcFlag struct {
+	value string
+	set   func(string) error
+}
+
+func (f *funcFlag) String() string { return f.value }
+
+func (f *funcFlag) Set(value string) error {
+	f.value = value
+	return f.set(value)
+}
+
+func funcVar(name, usage string, set func(string) error) {
+	flag.Var(&funcFlag{set: set}, name, usage)
+}
+
+// Flags whose values are left out of the -report, marked by secretVar where they are defined
+var secretFlags = make(map[string]bool)
+
+// Define a string flag holding a password, secret or token
+func secretVar(p *string, name, value, usage string) {
+	flag.StringVar(p, name, value, usage)
+	secretFlags[name] = true
+}
+
+// Environment variables, also the keys of a -config file, that flags fall back to
+var envFlags = map[string]string{
+	"url":                 "KC_URL",
+	"admin-user":          "KC_ADMIN_USER",
+	"admin-password":      "KC_ADMIN_PASSWORD",
+	"admin-password-file": "KC_ADMIN_PASSWORD_FILE",
+	"client-id":           "KC_CLIENT_ID",
+	"client-secret":       "KC_CLIENT_SECRET",
+	"client-secret-file":  "KC_CLIENT_SECRET_FILE",
+	"realm":               "KC_REALM",
+	"ca-file":             "KC_CA_FILE",
+	"tls-cert":            "KC_TLS_CERT",
+	"tls-key":             "KC_TLS_KEY",
+	"subgroups":           "KC_SUBGROUPS",
+	"users-per-subgroup":  "KC_USERS_PER_SUBGROUP",
+}
+
+// Parse command line flags into config
+func parseFlags() {
+	flag.StringVar(&config.configFile, "config", envOr("KC_CONFIG", ""), "file of KEY=value lines setting the KC_* variables below, overridden by the environment and flags (env KC_CONFIG)")
+	flag.StringVar(&config.url, "url", envOr("KC_URL", "http://192.168.0.66:8080"), "Keycloak base URL (env KC_URL)")
+	flag.StringVar(&config.adminUser, "admin-user", envOr("KC_ADMIN_USER", "admin"), "admin username (env KC_ADMIN_USER)")
+	secretVar(&config.adminPassword, "admin-password", envOr("KC_ADMIN_PASSWORD", "admin"), "admin password (env KC_ADMIN_PASSWORD), visible in the process list, prefer the env or -admin-password-file")
+	flag.StringVar(&config.adminPasswordFile, "admin-password-file", envOr("KC_ADMIN_PASSWORD_FILE", ""), "read the admin password from this file (env KC_ADMIN_PASSWORD_FILE)")
+	flag.StringVar(&config.clientID, "client-id", envOr("KC_CLIENT_ID", ""), "log in with the service account of this confidential client instead of the admin user (env KC_CLIENT_ID)")
+	secretVar(&config.clientSecret, "client-secret", envOr("KC_CLIENT_SECRET", ""), "secret of -client-id (env KC_CLIENT_SECRET), prefer the env or -client-secret-file")
+	flag.StringVar(&config.clientSecretFile, "client-secret-file", envOr("KC_CLIENT_SECRET_FILE", ""), "read the secret of -client-id from this file (env KC_CLIENT_SECRET_FILE)")
+	flag.StringVar(&config.realm, "realm", envOr("KC_REALM", "master"), "realm to log in to and create users in (env KC_REALM)")
+	flag.StringVar(&config.caFile, "ca-file", envOr("KC_CA_FILE", ""), "PEM bundle of CA certificates trusted for the Keycloak URL in addition to the system ones (env KC_CA_FILE)")
+	flag.StringVar(&config.tlsCertFile, "tls-cert", envOr("KC_TLS_CERT", ""), "PEM client certificate presented to Keycloak for mutual TLS, with -tls-key (env KC_TLS_CERT)")
+	flag.StringVar(&config.tlsKeyFile, "tls-key", envOr("KC_TLS_KEY", ""), "PEM private key of -tls-cert (env KC_TLS_KEY)")
+	flag.BoolVar(&config.insecureSkipVerify, "insecure-skip-verify", false, "don't verify the certificate of Keycloak, for test setups only")
+	flag.IntVar(&config.subgroups, "subgroups", envIntOr("KC_SUBGROUPS", 10), "subgroups created under every group (env KC_SUBGROUPS)")
+	flag.IntVar(&config.usersPerSubgroup, "users-per-subgroup", envIntOr("KC_USERS_PER_SUBGROUP", 10), "users created in every subgroup (env KC_USERS_PER_SUBGROUP)")
+	funcVar("realms", "comma-separated realms to spread the group trees over in turn, managed from the -realm login (default the login realm)", func(value string) error {
+		config.realms = strings.Split(value, ",")
+		return nil
+	})
+	flag.BoolVar(&config.createRealms, "create-realms", false, "create the -realms that don't exist yet, with registration on and default token lifetimes")
+	flag.IntVar(&config.groupDepth, "group-depth", 2, "levels of the group hierarchy including the top-level group, users are created in the deepest")
+	funcVar("fan-out", "comma-separated subgroups per group for each level below the top, e.g. 5,3,8 for four levels; overrides -subgroups and -group-depth", func(value string) error {
+		config.fanOut = nil
+		for _, field := range strings.Split(value, ",") {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid subgroup count %q", field)
+			}
+			config.fanOut = append(config.fanOut, n)
+		}
+		return nil
+	})
+	flag.IntVar(&config.totalUsers, "total-users", 0, "stop once this many users have been attempted, failed creates count too (default run until stopped)")
+	flag.IntVar(&config.totalUsers, "max-users", 0, "same as -total-users, failed creates count towards it")
+	flag.DurationVar(&config.duration, "duration", 0, "drain and stop the run after this long, e.g. 30m (default run until stopped)")
+	flag.IntVar(&config.maxErrors, "max-errors", 0, "drain and stop the run, exiting non-zero, once more than this many operations failed (default no limit)")
+	funcVar("error-rate-threshold", fmt.Sprintf("drain and stop the run, exiting non-zero, once more than this percentage of operations failed, e.g. 5%%, checked from %d operations on (default no limit)", errorRateMinOperations), func(value string) error {
+		var err error
+		config.errorRateThreshold, err = parsePercentage(value)
+		return err
+	})
+	flag.IntVar(&config.workers, "workers", 1, "number of group trees created in parallel")
+	flag.IntVar(&config.concurrency, "concurrency", 1, "number of users created in parallel in every group tree")
+	flag.Float64Var(&config.rate, "rate", 0, "limit group, subgroup and user creation to this many calls per second in total (default unlimited)")
+	flag.StringVar(&config.prefix, "prefix", "", "prefix of generated group and user names, to tell test data apart in the admin console")
+	flag.IntVar(&config.maxRetries, "max-retries", 3, "retries of a create failing with a network error, timeout, 429, 502, 503 or 504")
+	flag.DurationVar(&config.subgroupDelay, "subgroup-delay", 0, "pause after queueing the users of each subgroup")
+	flag.IntVar(&config.removeMemberships, "remove-memberships", 0, "remove N random user group memberships from the existing users instead of creating groups and users")
+	flag.BoolVar(&config.skipPreflight, "skip-preflight", false, "skip probing the admin permissions needed by the configured mode before starting")
+	flag.IntVar(&config.registerUsers, "register-users", 0, "register N users through the realm's self-registration form instead of creating them as admin")
+	flag.StringVar(&config.registrationClient, "registration-client", "account-console", "client whose login pages are used for self-registration")
+	secretVar(&config.registrationPassword, "registration-password", "Passw0rd!", "password submitted for self-registered users")
+	flag.StringVar(&config.hdrOut, "hdr-out", "", "append latencies to this file in HdrHistogram interval log format every time metrics are printed")
+	flag.StringVar(&config.nameCollisionStrategy, "name-collision-strategy", keycloakload.CollisionFail, "what to do when a generated group, subgroup or user name already exists: fail, skip, suffix or reuse")
+	flag.BoolVar(&config.upsert, "upsert", false, "reuse groups and users that already exist and fill in their missing attributes and group memberships, so that reruns converge; implies -name-collision-strategy reuse")
+	flag.BoolVar(&config.summaryOnSignal, "summary-on-signal", true, "print metrics immediately when the process receives SIGUSR2")
+	flag.StringVar(&config.metricsAddr, "metrics-addr", "", "serve counters and latency histograms in Prometheus format on this address, e.g. :9100, at /metrics")
+	flag.StringVar(&config.webhookURL, "webhook-url", "", "POST a JSON event to this URL after every successful group, subgroup and user creation")
+	flag.IntVar(&config.webhookQueueSize, "webhook-queue", 1000, "maximum number of webhook events waiting for delivery before new ones are dropped")
+	flag.IntVar(&config.maintainPopulation, "maintain-population", 0, "create users until N population users exist, then keep deleting random ones and creating replacements")
+	flag.DurationVar(&config.churnInterval, "churn-interval", time.Second, "pause between delete/replace cycles in -maintain-population mode")
+	secretVar(&config.token, "token", "", "use this admin access token instead of logging in as the admin user")
+	flag.StringVar(&config.tokenFile, "token-file", "", "read the admin access token from this file instead of logging in, re-reading it when the token is about to expire")
+	secretVar(&config.refreshToken, "refresh-token", "", "refresh token used to renew a supplied -token before it expires")
+	flag.BoolVar(&config.deterministic, "deterministic", false, "make runs reproducible: fixed random seed and sequential instead of timestamp-based names")
+	flag.Int64Var(&config.seed, "seed", 0, "seed for random choices (default random, or 1 with -deterministic)")
+	funcVar("actions-email", "comma-separated required actions, e.g. UPDATE_PASSWORD,VERIFY_EMAIL, to e-mail to every created user", func(value string) error {
+		config.actionsEmail = strings.Split(value, ",")
+		return nil
+	})
+	flag.DurationVar(&config.actionsLifespan, "actions-lifespan", 12*time.Hour, "how long the links in actions e-mails stay valid")
+	config.emailDomains = []string{"example.com"}
+	funcVar("email-domain", "comma-separated domains of the e-mail addresses given to created users, picked at random (default example.com)", func(value string) error {
+		config.emailDomains = strings.Split(value, ",")
+		return nil
+	})
+	flag.StringVar(&config.userData, "user-data", keycloakload.UserDataStamp, "data of generated users: stamp for timestamp-based names only, realistic for names, e-mail addresses, locale, department and employeeID attributes")
+	funcVar("username-template", "template of generated usernames over the fields of UserData, e.g. {{.Prefix}}{{.First}}.{{.Last}}{{.Index}}", func(value string) error {
+		_, err := keycloakload.ParseTemplate("username", value)
+		config.usernameTemplate = value
+		return err
+	})
+	funcVar("email-template", "template of the e-mail addresses of generated users over the fields of UserData, e.g. {{.First}}.{{.Last}}@{{.Domain}}", func(value string) error {
+		_, err := keycloakload.ParseTemplate("email", value)
+		config.emailTemplate = value
+		return err
+	})
+	config.locales = []string{"en"}
+	funcVar("locales", "comma-separated locales whose name lists realistic users are drawn from: en, de, es, fr (default en)", func(value string) error {
+		var err error
+		config.locales, err = parseLocales(value)
+		return err
+	})
+	flag.IntVar(&config.attributes.Count, "attributes", 0, "custom attributes given to every generated user, to measure the impact of attribute payload size on CreateUser and GetUsers")
+	flag.IntVar(&config.attributes.KeySize, "attribute-key-size", 16, "length of the keys of -attributes")
+	config.attributes.MinValueSize, config.attributes.MaxValueSize = 64, 64
+	funcVar("attribute-value-size", "length of the values of -attributes, or a min-max range they are drawn from (default 64)", func(value string) error {
+		var err error
+		config.attributes.MinValueSize, config.attributes.MaxValueSize, err = parseSizeRange(value)
+		return err
+	})
+	flag.IntVar(&config.attributes.Values, "attribute-values", 1, "values of each of -attributes, more than one makes them multi-valued")
+	flag.StringVar(&config.failuresOut, "failures-out", "", "write every failed operation with its entity, status and error to this JSON file")
+	flag.IntVar(&config.failuresMax, "failures-max", 10000, "maximum number of failed operations kept for -failures-out")
+	flag.StringVar(&config.groupSizeHistogram, "users-per-group-from-histogram", "", "file of \"users: groups\" lines giving how many subgroups get each number of users")
+	flag.BoolVar(&config.groupRoles, "group-roles", false, "also create a realm role named after every group and subgroup and map it to the group")
+	flag.BoolVar(&config.verifyGroupCount, "verify-group-count", false, "after creating each group tree, check that the group has all its subgroups and count any shortfall")
+	flag.StringVar(&config.rawLatencyOut, "raw-latency-out", "", "write the timestamp, operation, latency and status of every single request to this CSV file (grows large)")
+	funcVar("measure-ops", "comma-separated operations, e.g. create_user, that feed the aggregate latency metrics (default all)", func(value string) error {
+		config.measureOps = strings.Split(value, ",")
+		return nil
+	})
+	flag.StringVar(&config.recordOut, "record-out", "", "record the created groups, subgroups and users with their timing to this file for -replay")
+	flag.StringVar(&config.replay, "replay", "", "reproduce the operations and pacing of a run recorded with -record-out, then exit")
+	flag.BoolVar(&config.groupDepthLatency, "group-depth-latency", false, "report average and p95 latency of group and user creation by depth in the group hierarchy")
+	flag.StringVar(&config.federatedUsers, "federated-users", "", "create the users in this CSV file of username,idpAlias,externalId lines, each linked to the identity provider, then exit")
+	flag.StringVar(&config.importFile, "import", "", "create the users in this JSON array or CSV file with a header line (username, email, firstName, lastName, group, password, temporaryPassword, other columns become attributes), then exit")
+	flag.DurationVar(&config.outageThreshold, "outage-threshold", 0, "when every request has failed for this long, pause until Keycloak is ready again and resume (default off)")
+	flag.DurationVar(&config.progressInterval, "progress-interval", 0, "log completion percentage and ETA this often in modes with a known total, -remove-memberships, -register-users, -import, -total-users, -verify and the cleanup modes (default off)")
+	flag.StringVar(&config.assertSpec, "assert-spec", "", "check that the groups, subgroups and users in this JSON spec file exist as specified, then exit, non-zero on any mismatch")
+	flag.IntVar(&config.assertMaxDiscrepancies, "assert-max-discrepancies", 20, "number of discrepancies reported by -assert-spec")
+	secretVar(&config.userPassword, "user-password", "", "password set on every created user (default none, users can't log in)")
+	flag.BoolVar(&config.randomPasswords, "random-passwords", false, "set a generated password on every created user and log it")
+	flag.BoolVar(&config.temporaryPassword, "temporary-password", false, "make users change the password set by -user-password, -random-passwords or an -import password column at first login")
+	funcVar("required-actions", "comma-separated required actions, e.g. VERIFY_EMAIL,UPDATE_PASSWORD, given to every created user", func(value string) error {
+		config.requiredActions = strings.Split(value, ",")
+		return nil
+	})
+	funcVar("user-roles", "comma-separated role=weight entries, e.g. viewer=80,admin=20 or my-client/editor=1 for a client role, one of which is assigned to every created user; missing roles are created", func(value string) error {
+		var err error
+		config.userRoles, err = parseUserRoles(value)
+		return err
+	})
+	funcVar("scenario", "comma-separated operation=weight entries of get-users, get-groups, login, create and update, e.g. get-users=30,get-groups=30,login=20,create=15,update=5, run by -concurrency workers against the realm until stopped instead of creating group trees", func(value string) error {
+		var err error
+		config.scenario, err = parseScenario(value)
+		return err
+	})
+	funcVar("log-level", "lowest level logged to the console: debug for a line per created entity, info, warn or error (default info)", func(value string) error {
+		return config.logLevel.UnmarshalText([]byte(value))
+	})
+	flag.StringVar(&config.logFormat, "log-format", logFormatText, "format of the console log: text or json")
+	flag.BoolVar(&config.logSummaryOnly, "log-summary-only", false, "only log warnings, errors and a metrics summary every -summary-interval to the console")
+	flag.DurationVar(&config.summaryInterval, "summary-interval", 0, "print the metrics this often instead of after every group tree (default 30s with -log-summary-only)")
+	flag.StringVar(&config.requestLog, "request-log", "", "write every log record down to debug level, including one per created entity, to this file as JSON lines")
+	flag.StringVar(&config.report, "report", "", "at the end of the run write totals, latency by operation, errors, throughput per minute and the configuration to this .json or .csv file")
+	flag.StringVar(&config.output, "output", "", "keep the IDs and names of all created groups, subgroups and users in this JSON file as the run proceeds")
+	flag.BoolVar(&config.resume, "resume", false, "continue the interrupted run recorded in the -output file, completing its group trees and adding to the file")
+	flag.StringVar(&config.verify, "verify", "", "check that every group, subgroup and user recorded in this -output file still exists and that users are enabled members of their subgroup, then exit, non-zero on any drift")
+	flag.StringVar(&config.cleanup, "cleanup", "", "delete the users, subgroups and groups recorded in this -output file, then exit")
+	flag.BoolVar(&config.cleanupGenerated, "cleanup-generated", false, "delete every user the run generated or named like it and every group named like the generated ones, with the current -prefix, then exit")
+	flag.BoolVar(&config.dryRun, "dry-run", false, "with -cleanup or -cleanup-generated, only log what would be deleted")
+	flag.Parse()
+
+	if config.configFile != "" {
+		applyConfigFile(config.configFile)
+	}
+	if config.adminPasswordFile != "" {
+		data, err := os.ReadFile(config.adminPasswordFile)
+		if err != nil {
+			log.Fatalf("Failed to read admin password: %v", err)
+		}
+		config.adminPassword = strings.TrimSpace(string(data))
+	}
+	if config.clientSecretFile != "" {
+		data, err := os.ReadFile(config.clientSecretFile)
+		if err != nil {
+			log.Fatalf("Failed to read client secret: %v", err)
+		}
+		config.clientSecret = strings.TrimSpace(string(data))
+	}
+
+	if config.duration < 0 || config.maxErrors < 0 {
+		log.Fatalf("-duration and -max-errors must not be negative")
+	}
+	if config.resume && config.output == "" {
+		log.Fatalf("-resume needs the -output file of the run to resume")
+	}
+
+	if usingClientCredentials() && config.clientSecret == "" {
+		log.Fatalf("-client-id needs -client-secret or -client-secret-file, the client credentials grant only works for confidential clients")
+	}
+
+	if config.url == "" {
+		log.Fatalf("Keycloak URL is empty, set -url or KC_URL")
+	}
+	if u, err := url.Parse(config.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Fatalf("Invalid Keycloak URL %q, expected e.g. http://localhost:8080", config.url)
+	}
+	if (config.tlsCertFile == "") != (config.tlsKeyFile == "") {
+		log.Fatalf("-tls-cert and -tls-key must be given together")
+	}
+	if config.subgroups < 0 || config.usersPerSubgroup < 0 {
+		log.Fatalf("-subgroups and -users-per-subgroup must not be negative")
+	}
+	if len(config.fanOut) > 0 {
+		config.groupDepth = len(config.fanOut) + 1
+	}
+	if config.groupDepth < 2 {
+		log.Fatalf("-group-depth must be at least 2, users are created in subgroups")
+	}
+	if config.totalUsers < 0 {
+		log.Fatalf("-total-users must not be negative")
+	}
+	if config.userPassword != "" && config.randomPasswords {
+		log.Fatalf("-user-password and -random-passwords are mutually exclusive")
+	}
+	if config.maxRetries < 0 {
+		log.Fatalf("-max-retries must not be negative")
+	}
+	if config.concurrency < 1 || config.workers < 1 {
+		log.Fatalf("-concurrency and -workers must be at least 1")
+	}
+	if config.upsert {
+		if config.nameCollisionStrategy != keycloakload.CollisionFail && config.nameCollisionStrategy != keycloakload.CollisionReuse {
+			log.Fatalf("-upsert reuses existing entities, it can't be combined with -name-collision-strategy %s", config.nameCollisionStrategy)
+		}
+		config.nameCollisionStrategy = keycloakload.CollisionReuse
+	}
+	if !slices.Contains(keycloakload.CollisionStrategies, config.nameCollisionStrategy) {
+		log.Fatalf("Invalid -name-collision-strategy %q, must be one of %v", config.nameCollisionStrategy, keycloakload.CollisionStrategies)
+	}
+	if !slices.Contains(logFormats, config.logFormat) {
+		log.Fatalf("Invalid -log-format %q, must be one of %v", config.logFormat, logFormats)
+	}
+	if config.summaryInterval < 0 {
+		log.Fatalf("-summary-interval must not be negative")
+	}
+	if !slices.Contains(keycloakload.UserDataKinds, config.userData) {
+		log.Fatalf("Invalid -user-data %q, must be one of %v", config.userData, keycloakload.UserDataKinds)
+	}
+	if config.token != "" && config.tokenFile != "" {
+		log.Fatalf("-token and -token-file are mutually exclusive")
+	}
+	for _, op := range config.measureOps {
+		if !slices.Contains(allOperations, op) {
+			log.Fatalf("Invalid -measure-ops operation %q, must be one of %v", op, allOperations)
+		}
+	}
+}
+
+// Value of an environment variable, or def if it isn't set
+func envOr(key, def string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return def
+}
+
+// Integer value of an environment variable, or def if it isn't set
+func envIntOr(key string, def int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q, must be a number", key, value)
+	}
+	return n
+}
+
+// Set the flags that neither the command line nor the environment gave a value from a config file
+func applyConfigFile(path string) {
+	values, err := godotenv.Read(path)
+	if err != nil {
+		log.Fatalf("Failed to read config file: %v", err)
+	}
+
+	setOnCommandLine := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	for name, key := range envFlags {
+		value, ok := values[key]
+		if !ok || setOnCommandLine[name] {
+			continue
+		}
+		if _, inEnv := os.LookupEnv(key); inEnv {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			log.Fatalf("Invalid %s in %s: %v", key, path, err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"syscall"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Retry idempotent requests made through restyClient once when the server closed the
+// keep-alive connection under them. Every other error is returned as it is.
+func retryConnectionResets(restyClient *resty.Client) {
+	restyClient.SetRetryCount(1)
+	restyClient.AddRetryCondition(func(resp *resty.Response, err error) bool {
+		return isConnectionReset(err) && resp != nil && resp.Request != nil && isIdempotent(resp.Request.Method)
+	})
+	// Resty runs the condition and its hooks after the last attempt too, only the resets that
+	// are retried are logged and counted
+	restyClient.AddRetryHook(func(resp *resty.Response, err error) {
+		if resp == nil || resp.Request == nil || resp.Request.Attempt > restyClient.RetryCount {
+			return
+		}
+		slog.Warn("Retrying after connection reset", "method", resp.Request.Method, "url", resp.Request.URL, "err", err)
+		updateConnectionResetMetrics()
+	})
+}
+
+// Whether err means the server closed an idle connection just as it was reused
+func isConnectionReset(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Update connection reset metrics
+func updateConnectionResetMetrics() {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.connectionResets++
+}
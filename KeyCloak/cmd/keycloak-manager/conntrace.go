@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http/httptrace"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Count whether each request made through restyClient reused a keep-alive connection
+func traceConnections(restyClient *resty.Client) {
+	restyClient.OnBeforeRequest(func(c *resty.Client, req *resty.Request) error {
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				updateConnectionMetrics(info.Reused)
+			},
+		}
+		req.SetContext(httptrace.WithClientTrace(req.Context(), trace))
+		return nil
+	})
+}
+
+// Update connection reuse metrics
+func updateConnectionMetrics(reused bool) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	if reused {
+		metrics.reusedConns++
+	} else {
+		metrics.newConns++
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"sort"
+
+	"keycloak-manager/keycloakload"
+)
+
+// Print latency by hierarchy depth
+func printDepthMetrics(snapshot keycloakload.MetricsSnapshot) {
+	keys := make([]keycloakload.DepthKey, 0, len(snapshot.Depths))
+	for key := range snapshot.Depths {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Depth != keys[j].Depth {
+			return keys[i].Depth < keys[j].Depth
+		}
+		return keys[i].Op < keys[j].Op
+	})
+	for _, key := range keys {
+		stats := snapshot.Depths[key]
+		summaryLog.Printf("Depth %d %s: count=%d avg=%v p95=%v", key.Depth, key.Op, stats.Count, stats.Mean, stats.P95)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Source of every random choice the tool makes, so that its seed can be fixed
+var rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// Guards rng where concurrent workers draw from it
+var rngMu sync.Mutex
+
+// Last stamp handed out
+var nameSeq atomic.Int64
+
+// Random number in [0, n), safe for concurrent workers
+func randomIndex(n int) int {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	return rng.Intn(n)
+}
+
+// Reseed the random source from -seed, which -deterministic defaults to 1
+func seedRandom() {
+	seed := config.seed
+	if seed == 0 && config.deterministic {
+		seed = 1
+	}
+	if seed == 0 {
+		return
+	}
+	rng = rand.New(rand.NewSource(seed))
+	slog.Info("Random seed", "seed", seed)
+}
+
+// Stamp that keeps generated names apart, unique within the process however fast it is called:
+// the current Unix time, moved past the last stamp when this is called more than once a second,
+// or the next number of a sequence in -deterministic mode so that runs produce identical names
+func nameStamp() int64 {
+	if config.deterministic {
+		return nameSeq.Add(1)
+	}
+	for {
+		last := nameSeq.Load()
+		stamp := max(time.Now().Unix(), last+1)
+		if nameSeq.CompareAndSwap(last, stamp) {
+			return stamp
+		}
+	}
+}
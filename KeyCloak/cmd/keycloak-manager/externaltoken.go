@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Expiration used for supplied tokens without an exp claim
+var neverExpires = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// Whether the admin token is supplied from outside, e.g. by a sidecar, instead of obtained with LoginAdmin
+func usingExternalToken() bool {
+	return config.token != "" || config.tokenFile != ""
+}
+
+// Load the supplied admin token from -token or -token-file
+func loadExternalToken() (*gocloak.JWT, time.Time, error) {
+	accessToken := config.token
+	if config.tokenFile != "" {
+		data, err := os.ReadFile(config.tokenFile)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		accessToken = strings.TrimSpace(string(data))
+	}
+	if accessToken == "" {
+		return nil, time.Time{}, errors.New("supplied token is empty")
+	}
+
+	expirationTime, err := tokenExpiry(accessToken)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return &gocloak.JWT{AccessToken: accessToken, RefreshToken: config.refreshToken}, expirationTime, nil
+}
+
+// Read the expiration of an access token without verifying it, Keycloak does that on every call
+func tokenExpiry(accessToken string) (time.Time, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(accessToken, claims); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse token: %v", err)
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse token expiration: %v", err)
+	}
+	if exp == nil {
+		return neverExpires, nil
+	}
+	return exp.Time, nil
+}
+
+// Check that Keycloak accepts the supplied token. A 403 still proves the token is valid,
+// the preflight reports which permissions are missing.
+func validateExternalToken(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) error {
+	_, err := client.GetRealm(ctx, token.AccessToken, realm)
+	if err != nil && !keycloakload.IsForbidden(err) {
+		return err
+	}
+	return nil
+}
+
+// Replace a supplied token that is about to expire, either by re-reading the token file
+// or with the supplied refresh token. There is no login to fall back on.
+func refreshExternalToken(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, expirationTime time.Time) (*gocloak.JWT, time.Time, error) {
+	switch {
+	case config.tokenFile != "":
+		// The token file is expected to be rotated before the token expires
+		newToken, newExpirationTime, err := loadExternalToken()
+		if err != nil {
+			slog.Error("Failed to reload token file", "err", err)
+		} else if newToken.AccessToken != token.AccessToken {
+			slog.Info("Reloaded token from file")
+			return newToken, newExpirationTime, nil
+		}
+
+	case token.RefreshToken != "":
+		slog.Debug("Refreshing supplied token")
+		startTime := time.Now()
+		newToken, err := client.RefreshToken(ctx, token.RefreshToken, adminClientID, "", config.realm)
+		latency := time.Since(startTime)
+
+		// Update latency metrics
+		updateLatencyMetrics(opTokenRefresh, latency)
+
+		if err == nil {
+			slog.Debug("Token refreshed", "latency", latency)
+			return newToken, time.Now().Add(time.Duration(newToken.ExpiresIn) * time.Second), nil
+		}
+		slog.Error("Failed to refresh supplied token", "err", err)
+	}
+
+	if time.Now().After(expirationTime) {
+		return nil, time.Time{}, errors.New("supplied token expired and could not be replaced")
+	}
+	return token, expirationTime, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+
+	"keycloak-manager/keycloakload"
+)
+
+// A single failed operation kept for the -failures-out report
+type Failure struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Entity    string    `json:"entity"`
+	Status    int       `json:"status"`
+	Error     string    `json:"error"`
+}
+
+// Contents of the -failures-out file
+type FailureReport struct {
+	// All failures seen, including those beyond the -failures-max kept
+	Total    int       `json:"total"`
+	Failures []Failure `json:"failures"`
+}
+
+// Remember a failed operation on an entity for the failure report
+func recordFailure(op, entity string, err error) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.failures.Total++
+	if len(metrics.failures.Failures) >= config.failuresMax {
+		return
+	}
+	metrics.failures.Failures = append(metrics.failures.Failures, Failure{
+		Time:      time.Now(),
+		Operation: op,
+		Entity:    entity,
+		Status:    keycloakload.StatusFromError(err),
+		Error:     err.Error(),
+	})
+}
+
+// Write the failure report, replacing the previous one so that the file is complete even if the run is killed.
+// Must be called with metrics.mu held.
+func writeFailures(path string) {
+	data, err := json.MarshalIndent(metrics.failures, "", "  ")
+	if err != nil {
+		slog.Error("Failed to encode failure report", "err", err)
+		return
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		slog.Error("Failed to write failure report", "err", err)
+		return
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		slog.Error("Failed to write failure report", "err", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Create the users listed in a CSV file of "username,idpAlias,externalId" lines,
+// each linked to the identity provider as if it had logged in through it
+func linkFederatedUsers(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Requests in flight finish after a shutdown signal, which is only checked between steps
+	shutdown, ctx := ctx, context.WithoutCancel(ctx)
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 3
+	for shutdown.Err() == nil {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		userName, idpAlias, externalID := record[0], record[1], record[2]
+
+		token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+
+		// The named user gets no generated data, only the rate, retries and collision handling
+		created, err := createGeneratedUser(ctx, realm, keycloakload.User{Username: userName}, keycloakload.Group{})
+		if err != nil {
+			continue
+		}
+		userID, userName := created.ID, created.Name
+
+		switch created.Outcome {
+		case keycloakload.OutcomeSkipped:
+			continue
+		case keycloakload.OutcomeReused:
+			slog.Debug("Reusing existing user", "user", userName, "id", userID)
+		default:
+			slog.Debug("Created user", "user", userName, "id", userID)
+			incrementUserCounter()
+			registry.addUngroupedUser(userID, userName)
+			notifyWebhook("user", userName, userID, realm)
+			setUserPassword(ctx, client, token, realm, userID, userName)
+		}
+
+		err = linkIdentity(ctx, client, token, realm, userID, userName, idpAlias, externalID)
+		incrementIdentityLinkCounter(err == nil)
+		if err != nil {
+			slog.Error("Failed to link user", "user", userName, "idp", idpAlias, "err", err)
+			updateErrorMetrics(keycloakload.StatusFromError(err))
+			recordFailure(opLinkIdentity, userName+" "+idpAlias, err)
+			continue
+		}
+		slog.Debug("Linked user", "user", userName, "idp", idpAlias, "externalId", externalID)
+	}
+	return nil
+}
+
+// Link a user to an identity provider account and check that Keycloak reports the link
+func linkIdentity(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, userID, userName, idpAlias, externalID string) error {
+	startTime := time.Now()
+	err := client.CreateUserFederatedIdentity(ctx, token.AccessToken, realm, userID, idpAlias, gocloak.FederatedIdentityRepresentation{
+		IdentityProvider: &idpAlias,
+		UserID:           &externalID,
+		UserName:         &userName,
+	})
+	latency := time.Since(startTime)
+
+	// Update latency metrics
+	updateLatencyMetrics(opLinkIdentity, latency)
+
+	// A reused user may already carry the link
+	if err != nil && !keycloakload.IsConflict(err) {
+		return err
+	}
+
+	identities, err := client.GetUserFederatedIdentities(ctx, token.AccessToken, realm, userID)
+	if err != nil {
+		return fmt.Errorf("failed to verify link: %w", err)
+	}
+	for _, identity := range identities {
+		if identity.IdentityProvider != nil && *identity.IdentityProvider == idpAlias &&
+			identity.UserID != nil && *identity.UserID == externalID {
+			return nil
+		}
+	}
+	return fmt.Errorf("link to %s as %s missing after creation", idpAlias, externalID)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"keycloak-manager/keycloakload"
+)
+
+// Data of the users created by the run, set up from -user-data and its companion flags
+var userGenerator *keycloakload.Generator
+
+// Set up userGenerator, drawing its seed from rng so that -seed and -deterministic cover it
+func setupUserGenerator() error {
+	rngMu.Lock()
+	seed := rng.Int63()
+	rngMu.Unlock()
+
+	var err error
+	userGenerator, err = keycloakload.NewGenerator(keycloakload.GeneratorConfig{
+		Prefix:           config.prefix,
+		Kind:             config.userData,
+		UsernameTemplate: config.usernameTemplate,
+		EmailTemplate:    config.emailTemplate,
+		Locales:          config.locales,
+		EmailDomains:     config.emailDomains,
+		// Actions e-mails can only be sent to users with an address
+		StampEmails: len(config.actionsEmail) > 0,
+		Seed:        seed,
+		Attributes:  config.attributes,
+	})
+	return err
+}
+
+// Generate a user with userGenerator. A template failing on the data fails the create of the user,
+// which is logged and counted under its stamp name before the error is returned.
+func generateUser(stamp int64, userIdx int) (keycloakload.User, error) {
+	generated, err := userGenerator.User(stamp, userIdx)
+	if err != nil {
+		userName := keycloakload.StampUsername(config.prefix, stamp, userIdx)
+		slog.Error("Failed to generate user", "user", userName, "err", err)
+		updateOperationErrorMetrics(opCreateUser, keycloakload.StatusFromError(err))
+		recordFailure(opCreateUser, userName, err)
+		return keycloakload.User{}, fmt.Errorf("user %s: %w", userName, err)
+	}
+	return generated, nil
+}
+
+// Parse the comma-separated -locales, which must have name lists
+func parseLocales(value string) ([]string, error) {
+	locales := strings.Split(value, ",")
+	for _, locale := range locales {
+		if !slices.Contains(keycloakload.Locales(), locale) {
+			return nil, fmt.Errorf("unknown locale %q, must be one of %v", locale, keycloakload.Locales())
+		}
+	}
+	return locales, nil
+}
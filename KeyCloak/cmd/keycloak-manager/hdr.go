@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+var hdrLog *hdrhistogram.HistogramLogWriter
+
+// Open the HdrHistogram interval log and write its header
+func openHdrLog(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	startTime := time.Now().UnixMilli()
+	hdrLog = hdrhistogram.NewHistogramLogWriter(file)
+	hdrLog.SetBaseTime(startTime)
+	if err := hdrLog.OutputLogFormatVersion(); err != nil {
+		return err
+	}
+	if err := hdrLog.OutputStartTime(startTime); err != nil {
+		return err
+	}
+	if err := hdrLog.OutputBaseTime(startTime); err != nil {
+		return err
+	}
+	return hdrLog.OutputLegend()
+}
+
+// Append the latencies recorded since the last interval to the HdrHistogram log
+func writeHdrInterval() {
+	if err := hdrLog.OutputIntervalHistogram(loadMetrics.TakeInterval()); err != nil {
+		slog.Error("Failed to write HdrHistogram interval", "err", err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Group sizes still to be handed out from the current pass over the histogram, guarded by groupSizeMu
+var (
+	groupSizeDeck []int
+	groupSizeMu   sync.Mutex
+)
+
+// Group size histogram read from -users-per-group-from-histogram, bucket size to number of groups
+var groupSizeHistogram map[int]int
+
+// Load a group size histogram with one "users: groups" line per bucket, e.g. "25: 40"
+// for 40 groups of 25 users. Blank lines and lines starting with # are ignored.
+func loadGroupSizeHistogram(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	histogram := make(map[int]int)
+	totalGroups := 0
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		size, count, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("line %d: expected \"users: groups\", got %q", lineNo, line)
+		}
+		users, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil || users < 0 {
+			return fmt.Errorf("line %d: invalid group size %q", lineNo, size)
+		}
+		groups, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || groups < 0 {
+			return fmt.Errorf("line %d: invalid group count %q", lineNo, count)
+		}
+		histogram[users] += groups
+		totalGroups += groups
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(histogram) == 0 {
+		return fmt.Errorf("%s contains no buckets", path)
+	}
+	// There would be no group size to deal
+	if totalGroups == 0 {
+		return fmt.Errorf("%s counts no groups, every bucket is 0", path)
+	}
+
+	groupSizeHistogram = histogram
+	return nil
+}
+
+// Number of users for the next subgroup. With a histogram every size is dealt as often as
+// the histogram says before starting over in a new random order, so each pass reproduces it exactly.
+func nextGroupSize() int {
+	if groupSizeHistogram == nil {
+		return config.usersPerSubgroup
+	}
+
+	// Group trees are created by several -workers at once
+	groupSizeMu.Lock()
+	defer groupSizeMu.Unlock()
+
+	if len(groupSizeDeck) == 0 {
+		for users, groups := range groupSizeHistogram {
+			for i := 0; i < groups; i++ {
+				groupSizeDeck = append(groupSizeDeck, users)
+			}
+		}
+		// Map order isn't seeded, so sort before shuffling to keep -deterministic runs identical
+		slices.Sort(groupSizeDeck)
+		rngMu.Lock()
+		rng.Shuffle(len(groupSizeDeck), func(i, j int) {
+			groupSizeDeck[i], groupSizeDeck[j] = groupSizeDeck[j], groupSizeDeck[i]
+		})
+		rngMu.Unlock()
+	}
+
+	users := groupSizeDeck[len(groupSizeDeck)-1]
+	groupSizeDeck = groupSizeDeck[:len(groupSizeDeck)-1]
+	return users
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeHistogram(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "histogram.txt")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadGroupSizeHistogram(t *testing.T) {
+	t.Cleanup(func() { groupSizeHistogram, groupSizeDeck = nil, nil })
+
+	if err := loadGroupSizeHistogram(writeHistogram(t, "# users: groups\n5: 2\n\n10: 1\n5: 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if groupSizeHistogram[5] != 3 || groupSizeHistogram[10] != 1 {
+		t.Errorf("histogram = %v, want 3 groups of 5 and 1 of 10", groupSizeHistogram)
+	}
+
+	// A pass deals every size as often as the histogram says
+	dealt := make(map[int]int)
+	for range 4 {
+		dealt[nextGroupSize()]++
+	}
+	if dealt[5] != 3 || dealt[10] != 1 {
+		t.Errorf("dealt %v, want 3 groups of 5 and 1 of 10", dealt)
+	}
+}
+
+func TestLoadGroupSizeHistogramErrors(t *testing.T) {
+	t.Cleanup(func() { groupSizeHistogram = nil })
+
+	for _, content := range []string{
+		"",
+		"# only a comment\n",
+		"5 2\n",
+		"x: 2\n",
+		"5: -1\n",
+		// Every count 0 leaves nothing to deal
+		"5: 0\n10: 0\n",
+	} {
+		if err := loadGroupSizeHistogram(writeHistogram(t, content)); err == nil {
+			t.Errorf("loadGroupSizeHistogram(%q) succeeded", content)
+		}
+	}
+	if groupSizeHistogram != nil {
+		t.Errorf("rejected histogram was kept: %v", groupSizeHistogram)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"keycloak-manager/keycloakload"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Aggregate latency and errors by HTTP method for every request made through restyClient
+func traceMethods(restyClient *resty.Client) {
+	restyClient.OnAfterResponse(func(c *resty.Client, resp *resty.Response) error {
+		updateMethodMetrics(resp.Request.Method, resp)
+		return nil
+	})
+	restyClient.OnError(func(req *resty.Request, err error) {
+		// Requests that got no response at all
+		if _, ok := err.(*resty.ResponseError); !ok {
+			updateMethodMetrics(req.Method, nil)
+		}
+	})
+}
+
+// Update HTTP method metrics, resp is nil when the request failed without a response
+func updateMethodMetrics(method string, resp *resty.Response) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	methodMetrics, ok := metrics.methods[method]
+	if !ok {
+		methodMetrics = &keycloakload.OperationMetrics{}
+		metrics.methods[method] = methodMetrics
+	}
+
+	if resp == nil {
+		methodMetrics.RecordError()
+		return
+	}
+	methodMetrics.Record(resp.Time())
+	if resp.IsError() {
+		methodMetrics.RecordError()
+	}
+}
+
+// Copy of the HTTP method metrics
+func methodStats() map[string]keycloakload.OperationStats {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	stats := make(map[string]keycloakload.OperationStats, len(metrics.methods))
+	for method, methodMetrics := range metrics.methods {
+		stats[method] = methodMetrics.Stats()
+	}
+	return stats
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// A user to create in -import mode. In a CSV file the columns are named by a header line,
+// and every column without a field here becomes an attribute of the user.
+type ImportUser struct {
+	Username  string `json:"username"`
+	Email     string `json:"email,omitempty"`
+	FirstName string `json:"firstName,omitempty"`
+	LastName  string `json:"lastName,omitempty"`
+	// Path of an existing group the user becomes a member of, e.g. "/Sales/EMEA"
+	Group      string              `json:"group,omitempty"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+	// Password overriding -user-password and -random-passwords, temporary with -temporary-password
+	Password string `json:"password,omitempty"`
+	// Password the user has to change at first login, overriding all others
+	TemporaryPassword string `json:"temporaryPassword,omitempty"`
+}
+
+// Create the users listed in a JSON array or CSV file, chosen by the file extension
+func importUsers(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time, path string) error {
+	users, err := readImportFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	slog.Info("Importing users", "count", len(users), "file", path)
+
+	// Requests in flight finish after a shutdown signal, which is only checked between steps
+	shutdown, ctx := ctx, context.WithoutCancel(ctx)
+
+	startProgress(len(users))
+	for _, user := range users {
+		if shutdown.Err() != nil {
+			break
+		}
+		token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+		importUser(ctx, client, token, realm, user)
+		advanceProgress()
+	}
+	return nil
+}
+
+func importUser(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, user ImportUser) {
+	generated := user.user()
+	generated.RequiredActions = config.requiredActions
+	created, err := runner.CreateUser(ctx, realm, generated, keycloakload.Group{Path: user.Group})
+	userID, userName := created.ID, created.Name
+	if errors.Is(err, keycloakload.ErrStopped) {
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to import user", "user", userName, "err", err)
+		updateErrorMetrics(keycloakload.StatusFromError(err))
+		recordFailure(opCreateUser, userName, err)
+		return
+	}
+
+	switch created.Outcome {
+	case keycloakload.OutcomeSkipped:
+		return
+	case keycloakload.OutcomeReused:
+		if config.upsert {
+			upsertImportedUser(ctx, client, token, realm, userID, userName, user)
+		}
+		slog.Debug("Reusing existing user", "user", userName, "id", userID)
+		return
+	}
+	slog.Debug("Imported user", "user", userName, "id", userID)
+	incrementUserCounter()
+	assignUserRole(ctx, client, token, realm, userID, userName)
+
+	switch {
+	case user.TemporaryPassword != "":
+		setPassword(ctx, client, token, realm, userID, userName, user.TemporaryPassword, true)
+	case user.Password != "":
+		setPassword(ctx, client, token, realm, userID, userName, user.Password, config.temporaryPassword)
+	default:
+		setUserPassword(ctx, client, token, realm, userID, userName)
+	}
+}
+
+func readImportFile(path string) ([]ImportUser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var users []ImportUser
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.NewDecoder(file).Decode(&users)
+	} else {
+		users, err = readImportCSV(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, user := range users {
+		if user.Username == "" {
+			return nil, fmt.Errorf("user %d has no username", i+1)
+		}
+	}
+	return users, nil
+}
+
+func readImportCSV(r io.Reader) ([]ImportUser, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing header line: %v", err)
+	}
+
+	var users []ImportUser
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return users, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var user ImportUser
+		for i, value := range record {
+			if value == "" {
+				continue
+			}
+			switch column := strings.TrimSpace(header[i]); column {
+			case "username":
+				user.Username = value
+			case "email":
+				user.Email = value
+			case "firstName":
+				user.FirstName = value
+			case "lastName":
+				user.LastName = value
+			case "group":
+				user.Group = value
+			case "password":
+				user.Password = value
+			case "temporaryPassword":
+				user.TemporaryPassword = value
+			default:
+				if user.Attributes == nil {
+					user.Attributes = make(map[string][]string)
+				}
+				user.Attributes[column] = []string{value}
+			}
+		}
+		users = append(users, user)
+	}
+}
+
+// The user to create for u, under its own username
+func (u ImportUser) user() keycloakload.User {
+	user := keycloakload.User{
+		Username:  u.Username,
+		Email:     u.Email,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+	}
+	if len(u.Attributes) > 0 {
+		user.Attributes = u.Attributes
+	}
+	return user
+}
+
+// Fill in what an imported user that already exists is missing, and add it to its group
+func upsertImportedUser(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, userID, userName string, user ImportUser) {
+	err := upsertUser(ctx, client, token, realm, userID, user.user().Representation(userName))
+	if errors.Is(err, keycloakload.ErrStopped) {
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to update existing user", "user", userName, "err", err)
+		updateErrorMetrics(keycloakload.StatusFromError(err))
+		recordFailure(opUpdateUser, userName, err)
+		return
+	}
+	if user.Group == "" {
+		return
+	}
+	groupID, err := runner.LookupGroupID(ctx, realm, user.Group)
+	if err == nil {
+		err = client.AddUserToGroup(ctx, token.AccessToken, realm, userID, groupID)
+	}
+	if err != nil {
+		slog.Error("Failed to add existing user to group", "user", userName, "group", user.Group, "err", err)
+		updateErrorMetrics(keycloakload.StatusFromError(err))
+		recordFailure(opAddMembership, userName, err)
+	}
+}
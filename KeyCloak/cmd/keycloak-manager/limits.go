@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -error-rate-threshold is only checked once this many operations were measured,
+// so that a single early failure doesn't end the run
+const errorRateMinOperations = 100
+
+// Stop condition of a run that ended by -duration, -max-errors or -error-rate-threshold
+type runLimit struct {
+	reason string
+	// Whether the run failed a threshold, so that it exits non-zero
+	breached bool
+}
+
+func (l *runLimit) Error() string { return l.reason }
+
+// Cancel the run context with a cause, replaced in main
+var stopRun context.CancelCauseFunc = func(error) {}
+
+var stopRunOnce sync.Once
+
+// Drain and stop the run, logging the first limit it reached
+func stopAtLimit(limit *runLimit) {
+	stopRunOnce.Do(func() {
+		if limit.breached {
+			slog.Error("Stopping: " + limit.reason)
+		} else {
+			slog.Warn("Stopping: " + limit.reason)
+		}
+		stopRun(limit)
+	})
+}
+
+// Stop the run once -duration has passed
+func startDurationLimit() {
+	time.AfterFunc(config.duration, func() {
+		stopAtLimit(&runLimit{reason: fmt.Sprintf("-duration of %v reached", config.duration)})
+	})
+}
+
+// Description of the exceeded error threshold, or "" if none is
+func errorLimitBreach() string {
+	requests, errors := loadMetrics.Counts()
+	if config.maxErrors > 0 && errors > config.maxErrors {
+		return fmt.Sprintf("%d errors, more than -max-errors %d", errors, config.maxErrors)
+	}
+	if config.errorRateThreshold > 0 && requests >= errorRateMinOperations {
+		rate := float64(errors) * 100 / float64(requests)
+		if rate > config.errorRateThreshold {
+			return fmt.Sprintf("error rate %.2f%% above -error-rate-threshold %g%%", rate, config.errorRateThreshold)
+		}
+	}
+	return ""
+}
+
+// Stop the run if an error threshold is exceeded
+func checkErrorLimits() {
+	if reason := errorLimitBreach(); reason != "" {
+		stopAtLimit(&runLimit{reason: reason, breached: true})
+	}
+}
+
+// Parse an -error-rate-threshold percentage like 5% or 0.5
+func parsePercentage(value string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("invalid percentage %q", value)
+	}
+	return percent, nil
+}
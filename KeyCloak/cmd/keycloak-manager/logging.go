@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Formats of -log-format
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var logFormats = []string{logFormatText, logFormatJSON}
+
+// Metrics are summarized this often with -log-summary-only unless -summary-interval says otherwise
+const defaultSummaryInterval = 30 * time.Second
+
+// Logger of the metrics summaries and progress lines, which -log-summary-only keeps on the console
+var summaryLog = log.Default()
+
+// Route slog, and through it the log package, to the console at -log-level in -log-format.
+// With -request-log the per-object debug records also go to that file as JSON lines, whatever
+// the console shows.
+func setupLogging() error {
+	level := config.logLevel
+	if config.logSummaryOnly {
+		level = max(level, slog.LevelWarn)
+		if config.summaryInterval == 0 {
+			config.summaryInterval = defaultSummaryInterval
+		}
+	}
+
+	handlers := teeHandler{newLogHandler(os.Stderr, level)}
+	if config.requestLog != "" {
+		file, err := os.Create(config.requestLog)
+		if err != nil {
+			return err
+		}
+		handlers = append(handlers, slog.NewJSONHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+	slog.SetDefault(slog.New(handlers))
+	summaryLog = slog.NewLogLogger(newLogHandler(os.Stderr, slog.LevelInfo), slog.LevelInfo)
+
+	if config.summaryInterval > 0 {
+		go func() {
+			for range time.Tick(config.summaryInterval) {
+				printMetrics()
+			}
+		}()
+	}
+	return nil
+}
+
+func newLogHandler(w io.Writer, level slog.Level) slog.Handler {
+	options := &slog.HandlerOptions{Level: level}
+	if config.logFormat == logFormatJSON {
+		return slog.NewJSONHandler(w, options)
+	}
+	return slog.NewTextHandler(w, options)
+}
+
+// Print the metrics along the way, unless -summary-interval prints them periodically instead
+func printIntermediateMetrics() {
+	if config.summaryInterval > 0 {
+		return
+	}
+	printMetrics()
+}
+
+// Log at error level, which every -log-level shows, and exit non-zero
+func fatalf(format string, args ...any) {
+	slog.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// Hands every record to each of its handlers that takes the record's level
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	for _, h := range t {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// Logger of the resty clients, so that their messages follow -log-level and -log-format.
+// Failed requests are logged at warn level, the operation they belong to logs the error.
+type restyLogger struct{}
+
+func (restyLogger) Errorf(format string, v ...any) { slog.Warn(fmt.Sprintf(format, v...)) }
+func (restyLogger) Warnf(format string, v ...any)  { slog.Warn(fmt.Sprintf(format, v...)) }
+func (restyLogger) Debugf(format string, v ...any) { slog.Debug(fmt.Sprintf(format, v...)) }
@@ -0,0 +1,833 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Operations tracked in the per-operation metrics
+const (
+	opCreateGroupTree  = "create_group_tree"
+	opCreateGroup      = keycloakload.OpCreateGroup
+	opCreateSubgroup   = keycloakload.OpCreateSubgroup
+	opCreateUser       = keycloakload.OpCreateUser
+	opTokenRefresh     = "token_refresh"
+	opRemoveMembership = "remove_membership"
+	opRegistrationForm = "registration_form"
+	opRegisterUser     = "register_user"
+	opDeleteUser       = "delete_user"
+	opActionsEmail     = "execute_actions_email"
+	opAddMembership    = "add_membership"
+	opGetUserGroups    = "get_user_groups"
+	opCreateRole       = "create_role"
+	opMapGroupRole     = "map_group_role"
+	opVerifyGroup      = "verify_group"
+	opLinkIdentity     = "link_identity"
+	opSetPassword      = "set_password"
+	opDeleteGroup      = "delete_group"
+	opAssignUserRole   = "assign_user_role"
+	opGetUsers         = "get_users"
+	opGetGroups        = "get_groups"
+	opUserLogin        = "user_login"
+	opUpdateUser       = "update_user"
+)
+
+var allOperations = []string{
+	opCreateGroupTree, opCreateGroup, opCreateSubgroup, opCreateUser, opTokenRefresh, opRemoveMembership,
+	opRegistrationForm, opRegisterUser, opDeleteUser, opActionsEmail, opAddMembership, opGetUserGroups,
+	opCreateRole, opMapGroupRole, opVerifyGroup, opLinkIdentity, opSetPassword,
+	opDeleteGroup, opAssignUserRole, opGetUsers, opGetGroups, opUserLogin, opUpdateUser,
+}
+
+// Latency and errors of the operations of the run, set up in main from the flags
+var loadMetrics = keycloakload.NewMetrics(keycloakload.MetricsConfig{})
+
+// Metrics of the HTTP requests of the run, beyond the operations kept in loadMetrics
+type Metrics struct {
+	mu          sync.Mutex
+	methods     map[string]*keycloakload.OperationMetrics
+	failures    FailureReport
+	reusedConns int
+	newConns    int
+
+	// Idempotent requests retried after the server closed their keep-alive connection
+	connectionResets int
+}
+
+var metrics = Metrics{
+	methods: make(map[string]*keycloakload.OperationMetrics),
+}
+
+var (
+	totalGroupsCreated      int
+	totalUsersCreated       int
+	totalMembershipsRemoved int
+	totalUsersRegistered    int
+	totalUsersUpserted      int
+	totalWebhookFailures    int
+	totalUsersDeleted       int
+	totalRolesCreated       int
+	totalRoleMappings       int
+	totalGroupsShort        int
+	totalSubgroupsMissing   int
+	totalLinkAttempts       int
+	totalIdentitiesLinked   int
+	totalUserRoles          int
+	mu                      sync.Mutex // Mutex to prevent race conditions
+)
+
+func main() {
+	parseFlags()
+	if err := setupLogging(); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	seedRandom()
+	loadMetrics = keycloakload.NewMetrics(metricsConfig())
+	if err := setupUserGenerator(); err != nil {
+		fatalf("Invalid user data flags: %v", err)
+	}
+
+	if config.groupSizeHistogram != "" {
+		if err := loadGroupSizeHistogram(config.groupSizeHistogram); err != nil {
+			fatalf("Failed to load group size histogram: %v", err)
+		}
+	}
+
+	if config.hdrOut != "" {
+		if err := openHdrLog(config.hdrOut); err != nil {
+			fatalf("Failed to open HdrHistogram log: %v", err)
+		}
+	}
+
+	if config.resume {
+		if err := loadCheckpoint(config.output); err != nil {
+			fatalf("Failed to load checkpoint: %v", err)
+		}
+	}
+
+	if config.recordOut != "" {
+		if err := openRecording(config.recordOut); err != nil {
+			fatalf("Failed to open recording: %v", err)
+		}
+	}
+
+	if config.rawLatencyOut != "" {
+		if err := openRawLatencyLog(config.rawLatencyOut); err != nil {
+			fatalf("Failed to open raw latency log: %v", err)
+		}
+	}
+
+	if config.summaryOnSignal {
+		handleSummarySignal()
+	}
+
+	if config.metricsAddr != "" {
+		startMetricsServer(config.metricsAddr)
+	}
+
+	if config.webhookURL != "" {
+		startWebhook(config.webhookURL, config.webhookQueueSize)
+	}
+
+	client := gocloak.NewClient(config.url)
+	client.RestyClient().SetLogger(restyLogger{})
+	if customTLS() {
+		if err := configureTLS(client.RestyClient()); err != nil {
+			fatalf("TLS setup failed: %v", err)
+		}
+	}
+	traceConnections(client.RestyClient())
+	traceMethods(client.RestyClient())
+	retryConnectionResets(client.RestyClient())
+	if config.outageThreshold > 0 {
+		traceOutages(client.RestyClient())
+	}
+	if rawLatencyLog != nil {
+		traceRawLatency(client.RestyClient())
+	}
+	// Stop at the next clean boundary on Ctrl-C or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Stop the same way at -duration, -max-errors or -error-rate-threshold
+	ctx, stopRun = context.WithCancelCause(ctx)
+	runCtx = ctx
+	if config.duration > 0 {
+		startDurationLimit()
+	}
+
+	// Self-registration is anonymous, so it doesn't need an admin login
+	if config.registerUsers > 0 {
+		err := registerUsers(ctx, client, config.realm, config.registerUsers)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		finish(ctx)
+		return
+	}
+
+	// Authenticate with Keycloak
+	var token *gocloak.JWT
+	var expirationTime time.Time
+	if usingExternalToken() {
+		var err error
+		token, expirationTime, err = loadExternalToken()
+		if err != nil {
+			fatalf("Failed to load supplied token: %v", err)
+		}
+		if err := validateExternalToken(ctx, client, token, config.realm); err != nil {
+			fatalf("Supplied token rejected: %v", err)
+		}
+		slog.Info("Using supplied token", "expires", expirationTime)
+	} else {
+		var err error
+		token, err = login(ctx, client)
+		if err != nil {
+			fatalf("Login failed: %v", err)
+		}
+		expirationTime = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	tokens = newTokenManager(client, token, expirationTime)
+	go tokens.Run(ctx)
+	runner = newRunner(client, tokens)
+
+	if config.createRealms {
+		if err := createRealms(ctx, client, token); err != nil {
+			fatalf("Failed to create realms: %v", err)
+		}
+	}
+
+	if !config.skipPreflight {
+		for _, realm := range runRealms() {
+			if err := preflight(ctx, client, token, realm); err != nil {
+				fatalf("Preflight failed in realm %s: %v", realm, err)
+			}
+		}
+	}
+
+	if config.cleanup != "" {
+		err := cleanup(ctx, client, token, config.realm, expirationTime, config.cleanup)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		finish(ctx)
+		return
+	}
+
+	if config.cleanupGenerated {
+		err := cleanupGenerated(ctx, client, token, config.realm, expirationTime)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		finish(ctx)
+		return
+	}
+
+	if config.removeMemberships > 0 {
+		err := removeMemberships(ctx, client, token, config.realm, expirationTime, config.removeMemberships)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		finish(ctx)
+		return
+	}
+
+	if config.assertSpec != "" {
+		err := assertSpec(ctx, client, token, config.realm, expirationTime, config.assertSpec)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		finish(ctx)
+		// A mismatch fails the run even without a breached threshold
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if config.verify != "" {
+		err := verifyCreated(ctx, client, token, config.realm, expirationTime, config.verify)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		finish(ctx)
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if config.federatedUsers != "" {
+		err := linkFederatedUsers(ctx, client, token, config.realm, expirationTime, config.federatedUsers)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		finish(ctx)
+		return
+	}
+
+	if len(config.userRoles) > 0 {
+		for _, realm := range runRealms() {
+			if err := setupUserRoles(ctx, client, token, realm); err != nil {
+				fatalf("Failed to set up user roles in realm %s: %v", realm, err)
+			}
+		}
+	}
+
+	if config.importFile != "" {
+		err := importUsers(ctx, client, token, config.realm, expirationTime, config.importFile)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		finish(ctx)
+		return
+	}
+
+	if config.replay != "" {
+		err := replay(ctx, client, token, config.realm, expirationTime, config.replay)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		finish(ctx)
+		return
+	}
+
+	if len(config.scenario) > 0 {
+		err := runScenario(ctx, client, token, config.realm)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		finish(ctx)
+		return
+	}
+
+	if config.maintainPopulation > 0 {
+		err := maintainPopulation(ctx, client, token, config.realm, expirationTime, config.maintainPopulation)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		finish(ctx)
+		return
+	}
+
+	if config.totalUsers > 0 {
+		startProgress(config.totalUsers - int(usersReserved.Load()))
+	}
+	if config.resume {
+		token, expirationTime = resumeGroupTrees(ctx, client, token, config.realm, expirationTime)
+	}
+
+	// Every worker creates group trees one after another with its own pool of user workers
+	var wg sync.WaitGroup
+	for w := 0; w < config.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && !usersExhausted() {
+				// Check if the token has expired or is about to expire
+				token, expirationTime := sharedToken(ctx, client)
+
+				startTime := time.Now()
+				err := createGroupAndUsers(ctx, client, token, nextRealm(), expirationTime)
+				latency := time.Since(startTime)
+
+				updateLatencyMetrics(opCreateGroupTree, latency)
+
+				if err != nil {
+					slog.Error(err.Error())
+				}
+				printIntermediateMetrics()
+			}
+		}()
+	}
+	wg.Wait()
+	finish(ctx)
+}
+
+func createGroupAndUsers(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time) error {
+	// Requests in flight finish after a shutdown signal, which is only checked between subgroups
+	// so that no subgroup is left without its users
+	shutdown, ctx := ctx, context.WithoutCancel(ctx)
+
+	groupStamp := nameStamp()
+	created, err := runner.CreateGroup(ctx, realm, keycloakload.Group{}, newGroupName(groupStamp))
+	groupID, groupName := created.ID, created.Name
+	if errors.Is(err, keycloakload.ErrStopped) {
+		return nil
+	}
+	if err != nil {
+		updateErrorMetrics(keycloakload.StatusFromError(err))
+		recordFailure(opCreateGroup, groupName, err)
+		return fmt.Errorf("failed to create group: %v", err)
+	}
+
+	switch created.Outcome {
+	case keycloakload.OutcomeSkipped:
+		return nil
+	case keycloakload.OutcomeReused:
+		slog.Debug("Reusing existing group", "group", groupName, "id", groupID)
+	default:
+		slog.Debug("Created group", "group", groupName, "id", groupID)
+		incrementGroupCounter()
+		notifyWebhook("group", groupName, groupID, realm)
+	}
+	tree := groupNode{
+		id:       groupID,
+		name:     groupName,
+		path:     keycloakload.GroupPath(groupName),
+		stamp:    groupStamp,
+		level:    1,
+		regGroup: registry.addGroup(realm, groupID, groupName, created.Outcome == keycloakload.OutcomeReused),
+	}
+	if created.Outcome == keycloakload.OutcomeReused {
+		tree.line = recordReuse(opCreateGroup, groupName, groupStamp, 0)
+	} else {
+		tree.line = recordOperation(opCreateGroup, groupName, groupStamp, 0)
+	}
+
+	jobs, waitUsers := startUserWorkers(ctx, client, realm)
+
+	if config.groupRoles {
+		if err := createGroupRole(ctx, client, token, realm, groupID, groupName); err != nil {
+			slog.Error("Failed to create role for group", "group", groupName, "err", err)
+			updateErrorMetrics(keycloakload.StatusFromError(err))
+			recordFailure(opCreateRole, groupName, err)
+		}
+	}
+
+	token, expirationTime, _ = fillSubgroups(ctx, shutdown, client, token, realm, expirationTime, jobs, tree)
+
+	// Let the workers finish the queued users
+	close(jobs)
+	userErrs := waitUsers()
+
+	// An interrupted group is short of subgroups on purpose
+	if config.verifyGroupCount && shutdown.Err() == nil && !usersExhausted() {
+		verifySubgroupCount(ctx, client, token, realm, groupID, groupName, subgroupsAt(2))
+	}
+
+	if len(userErrs) > 0 {
+		return fmt.Errorf("failed to create %d users, first: %w", len(userErrs), userErrs[0])
+	}
+	return nil
+}
+
+// A group being filled with subgroups, and users once it is a leaf at -group-depth
+type groupNode struct {
+	id    string
+	name  string
+	path  string
+	stamp int64
+	// 1 for top-level groups
+	level int
+	// Line of the group in the -record-out file
+	line int
+	// Where the group is kept for -output, regGroup at level 1 and regSubgroup below
+	regGroup    *RegistryGroup
+	regSubgroup *RegistrySubgroup
+}
+
+func (n groupNode) leaf() bool {
+	return n.level == config.groupDepth
+}
+
+// The group to create subgroups or users in with the runner
+func (n groupNode) group() keycloakload.Group {
+	return keycloakload.Group{ID: n.id, Path: n.path, Level: n.level}
+}
+
+// Subgroups already recorded for -output, only there when resuming
+func (n groupNode) recordedSubgroups() []*RegistrySubgroup {
+	switch {
+	case n.regSubgroup != nil:
+		return n.regSubgroup.SubGroups
+	case n.regGroup != nil:
+		return n.regGroup.SubGroups
+	}
+	return nil
+}
+
+func subgroupName(groupName string, subGrpIdx int) string {
+	return keycloakload.SubgroupName(groupName, subGrpIdx)
+}
+
+// Create the subgroups of parent, each filled down to the leaves, which get their users queued.
+// Subgroups recorded by an interrupted run are completed instead. Returns true if the tree
+// stopped early for a shutdown or because -total-users is reached.
+func fillSubgroups(ctx, shutdown context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time, jobs chan<- userJob, parent groupNode) (*gocloak.JWT, time.Time, bool) {
+	recorded := make(map[string]*RegistrySubgroup)
+	for _, subGroup := range parent.recordedSubgroups() {
+		recorded[subGroup.Name] = subGroup
+	}
+
+	count := subgroupsAt(parent.level + 1)
+	for subGrpIdx := 1; subGrpIdx <= count; subGrpIdx++ {
+		if usersExhausted() {
+			return token, expirationTime, true
+		}
+
+		var subGroup groupNode
+		if regSubgroup, ok := recorded[subgroupName(parent.name, subGrpIdx)]; ok {
+			if !treeIncomplete(regSubgroup.Name, parent.level+1, regSubgroup.SubGroups, len(regSubgroup.Users)) {
+				continue
+			}
+			subGroup = groupNode{
+				id:          regSubgroup.ID,
+				name:        regSubgroup.Name,
+				path:        parent.path + keycloakload.GroupPath(regSubgroup.Name),
+				stamp:       parent.stamp,
+				level:       parent.level + 1,
+				line:        recordReuse(opCreateSubgroup, regSubgroup.Name, parent.stamp, parent.line),
+				regSubgroup: regSubgroup,
+			}
+			if subGroup.leaf() {
+				queueUsers(jobs, len(regSubgroup.Users)+1, config.usersPerSubgroup, subGroup)
+			}
+		} else {
+			var ok bool
+			subGroup, ok = createSubgroup(ctx, client, token, realm, parent, subGrpIdx)
+			if !ok {
+				continue
+			}
+			if subGroup.leaf() {
+				//create user in subgroup
+				queueUsers(jobs, 1, nextGroupSize(), subGroup)
+			}
+		}
+
+		if !subGroup.leaf() {
+			var stopped bool
+			token, expirationTime, stopped = fillSubgroups(ctx, shutdown, client, token, realm, expirationTime, jobs, subGroup)
+			if stopped {
+				return token, expirationTime, true
+			}
+		}
+
+		if !sleepContext(shutdown, config.subgroupDelay) {
+			slog.Warn("Shutting down, group stops early", "group", parent.path, "subgroups", subGrpIdx, "of", count)
+			return token, expirationTime, true
+		}
+		token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+	}
+	return token, expirationTime, false
+}
+
+// Create the subgroup numbered subGrpIdx in parent, returning false if it wasn't created
+func createSubgroup(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, parent groupNode, subGrpIdx int) (groupNode, bool) {
+	created, err := runner.CreateGroup(ctx, realm, parent.group(), subgroupName(parent.name, subGrpIdx))
+	subGrpID, subGrpName := created.ID, created.Name
+	if errors.Is(err, keycloakload.ErrStopped) {
+		return groupNode{}, false
+	}
+	if err != nil {
+		slog.Error("Failed to create subgroup", "group", subGrpName, "err", err)
+		updateErrorMetrics(keycloakload.StatusFromError(err))
+		recordFailure(opCreateSubgroup, parent.path+keycloakload.GroupPath(subGrpName), err)
+		return groupNode{}, false
+	}
+
+	switch created.Outcome {
+	case keycloakload.OutcomeSkipped:
+		return groupNode{}, false
+	case keycloakload.OutcomeReused:
+		slog.Debug("Reusing existing subgroup", "group", subGrpName, "id", subGrpID)
+	default:
+		slog.Debug("Created subgroup", "group", subGrpName, "id", subGrpID)
+		notifyWebhook("subgroup", subGrpName, subGrpID, realm)
+	}
+	subGroup := groupNode{
+		id:          subGrpID,
+		name:        subGrpName,
+		path:        parent.path + keycloakload.GroupPath(subGrpName),
+		stamp:       parent.stamp,
+		level:       parent.level + 1,
+		regSubgroup: registry.addSubgroup(parent.regGroup, parent.regSubgroup, subGrpID, subGrpName, created.Outcome == keycloakload.OutcomeReused),
+	}
+	if created.Outcome == keycloakload.OutcomeReused {
+		subGroup.line = recordReuse(opCreateSubgroup, subGrpName, parent.stamp, parent.line)
+	} else {
+		subGroup.line = recordOperation(opCreateSubgroup, subGrpName, parent.stamp, parent.line)
+	}
+
+	if config.groupRoles {
+		if err := createGroupRole(ctx, client, token, realm, subGrpID, subGrpName); err != nil {
+			slog.Error("Failed to create role for subgroup", "group", subGrpName, "err", err)
+			updateErrorMetrics(keycloakload.StatusFromError(err))
+			recordFailure(opCreateRole, subGrpName, err)
+		}
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	return subGroup, true
+}
+
+// Queue the users numbered first to last for a leaf subgroup, as far as -total-users allows
+func queueUsers(jobs chan<- userJob, first, last int, subGroup groupNode) {
+	last = first + reserveUsers(last-first+1) - 1
+	for userIdx := first; userIdx <= last; userIdx++ {
+		jobs <- userJob{
+			userIdx:     userIdx,
+			subGrpID:    subGroup.id,
+			subGrpPath:  subGroup.path,
+			subGrpLine:  subGroup.line,
+			regSubgroup: subGroup.regSubgroup,
+		}
+	}
+}
+
+// Refresh the token if it has expired or is about to expire, logging in again if the refresh fails
+// Once logged in, the token of the shared TokenManager is returned instead of the caller's copy
+func ensureValidToken(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, expirationTime time.Time) (*gocloak.JWT, time.Time) {
+	if tokens != nil {
+		return sharedToken(ctx, client)
+	}
+	return validToken(ctx, client, token, expirationTime)
+}
+
+// Waits out a Keycloak outage first when -outage-threshold is set
+func validToken(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, expirationTime time.Time) (*gocloak.JWT, time.Time) {
+	token, expirationTime = waitOutOutage(ctx, client, token, expirationTime)
+
+	if !keycloakload.Expiring(token, expirationTime) {
+		return token, expirationTime
+	}
+	return freshToken(ctx, client, token, expirationTime)
+}
+
+func freshToken(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, expirationTime time.Time) (*gocloak.JWT, time.Time) {
+	newToken, newExpirationTime, err := replaceToken(ctx, client, token, expirationTime)
+	if err != nil {
+		fatalf("Failed to reauthenticate: %v", err)
+	}
+	return newToken, newExpirationTime
+}
+
+// Replacement for the admin token, from -token or by refreshing or logging in again
+func replaceToken(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, expirationTime time.Time) (*gocloak.JWT, time.Time, error) {
+	if usingExternalToken() {
+		return refreshExternalToken(ctx, client, token, expirationTime)
+	}
+
+	slog.Debug("Refreshing token")
+	startTime := time.Now()
+	newToken, err := refreshLogin(ctx, client, token)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	latency := time.Since(startTime)
+
+	// Update latency metrics
+	updateLatencyMetrics(opTokenRefresh, latency)
+	slog.Debug("Token refreshed", "latency", latency)
+
+	return newToken, time.Now().Add(time.Duration(newToken.ExpiresIn) * time.Second), nil
+}
+
+func incrementGroupCounter() {
+	mu.Lock()
+	defer mu.Unlock()
+	totalGroupsCreated++
+}
+
+func incrementUserCounter() {
+	mu.Lock()
+	defer mu.Unlock()
+	totalUsersCreated++
+}
+
+func incrementMembershipRemovedCounter() {
+	mu.Lock()
+	defer mu.Unlock()
+	totalMembershipsRemoved++
+}
+
+func incrementUserRegisteredCounter() {
+	mu.Lock()
+	defer mu.Unlock()
+	totalUsersRegistered++
+}
+
+func incrementUpsertCounter() {
+	mu.Lock()
+	defer mu.Unlock()
+	totalUsersUpserted++
+}
+
+func incrementUserDeletedCounter() {
+	mu.Lock()
+	defer mu.Unlock()
+	totalUsersDeleted++
+}
+
+func incrementRoleCounter() {
+	mu.Lock()
+	defer mu.Unlock()
+	totalRolesCreated++
+}
+
+func incrementRoleMappingCounter() {
+	mu.Lock()
+	defer mu.Unlock()
+	totalRoleMappings++
+}
+
+func incrementIdentityLinkCounter(linked bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	totalLinkAttempts++
+	if linked {
+		totalIdentitiesLinked++
+	}
+}
+
+func incrementUserRoleCounter() {
+	mu.Lock()
+	defer mu.Unlock()
+	totalUserRoles++
+}
+
+func incrementSubgroupShortfallCounter(missing int) {
+	mu.Lock()
+	defer mu.Unlock()
+	totalGroupsShort++
+	totalSubgroupsMissing += missing
+}
+
+func incrementWebhookFailureCounter() {
+	mu.Lock()
+	defer mu.Unlock()
+	totalWebhookFailures++
+}
+
+// Update metrics for request latency
+func updateLatencyMetrics(op string, latency time.Duration) {
+	loadMetrics.RecordLatency(op, latency)
+}
+
+// Update error metrics
+func updateErrorMetrics(statusCode int) {
+	loadMetrics.RecordError("", statusCode)
+	checkErrorLimits()
+}
+
+// Update error metrics, also counting the error against op
+func updateOperationErrorMetrics(op string, statusCode int) {
+	loadMetrics.RecordError(op, statusCode)
+	checkErrorLimits()
+}
+
+// p50, p95 and p99 latency for the metrics summary
+func percentiles(stats keycloakload.OperationStats) string {
+	return fmt.Sprintf("p50=%v p95=%v p99=%v", stats.P50, stats.P95, stats.P99)
+}
+
+// Print metrics
+func printMetrics() {
+	snapshot := loadMetrics.Snapshot()
+	methods := methodStats()
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	summaryLog.Printf("Total groups created: %d", totalGroupsCreated)
+	summaryLog.Printf("Total users created: %d", totalUsersCreated)
+	summaryLog.Printf("Total users deleted: %d", totalUsersDeleted)
+	summaryLog.Printf("Total memberships removed: %d", totalMembershipsRemoved)
+	summaryLog.Printf("Total users registered: %d", totalUsersRegistered)
+	summaryLog.Printf("Total name collisions: %d", snapshot.Collisions)
+	if config.upsert {
+		summaryLog.Printf("Total users upserted: %d", totalUsersUpserted)
+	}
+	if config.groupRoles || len(config.userRoles) > 0 {
+		summaryLog.Printf("Total roles created: %d", totalRolesCreated)
+	}
+	if config.groupRoles {
+		summaryLog.Printf("Total group role mappings: %d", totalRoleMappings)
+	}
+	if len(config.userRoles) > 0 {
+		summaryLog.Printf("Total user role assignments: %d", totalUserRoles)
+	}
+	if totalLinkAttempts > 0 {
+		summaryLog.Printf("Total identity links verified: %d of %d (%.1f%%)", totalIdentitiesLinked, totalLinkAttempts,
+			float64(totalIdentitiesLinked)*100/float64(totalLinkAttempts))
+	}
+	summaryLog.Printf("Total retries: %d", snapshot.Retries)
+	if snapshot.ForbiddenRetries > 0 {
+		summaryLog.Printf("Total 403s retried with a fresh token: %d (%d resolved)", snapshot.ForbiddenRetries, snapshot.ForbiddenResolved)
+	}
+	if config.verifyGroupCount {
+		summaryLog.Printf("Total groups missing subgroups: %d (%d subgroups missing)", totalGroupsShort, totalSubgroupsMissing)
+	}
+	if config.webhookURL != "" {
+		summaryLog.Printf("Total webhook failures: %d", totalWebhookFailures)
+	}
+	summaryLog.Printf("Average Latency: %v", snapshot.AverageLatency())
+	summaryLog.Printf("Peak Latency: %v", snapshot.PeakLatency)
+	summaryLog.Printf("Total Errors: %d", snapshot.Errors)
+	summaryLog.Printf("Total Conflicts (already exists): %d", snapshot.Conflicts)
+
+	if conns := metrics.reusedConns + metrics.newConns; conns > 0 {
+		summaryLog.Printf("Connection reuse: %.1f%% (%d reused, %d new)",
+			float64(metrics.reusedConns)*100/float64(conns), metrics.reusedConns, metrics.newConns)
+	}
+	if metrics.connectionResets > 0 {
+		summaryLog.Printf("Connection resets retried: %d", metrics.connectionResets)
+	}
+
+	// Print latency by operation
+	for _, op := range sortedKeys(snapshot.Operations) {
+		opStats := snapshot.Operations[op]
+		opErrors := ""
+		if opStats.Errors > 0 {
+			opErrors = fmt.Sprintf(" errors=%d", opStats.Errors)
+		}
+		summaryLog.Printf("%s: count=%d%s avg=%v %s peak=%v", op, opStats.Count, opErrors,
+			opStats.Average(), percentiles(opStats), opStats.PeakLatency)
+	}
+
+	// Print latency by HTTP method
+	for _, method := range sortedKeys(methods) {
+		stats := methods[method]
+		summaryLog.Printf("HTTP %s: count=%d errors=%d avg=%v %s peak=%v", method, stats.Count,
+			stats.Errors, stats.Average(), percentiles(stats), stats.PeakLatency)
+	}
+
+	if config.groupDepthLatency {
+		printDepthMetrics(snapshot)
+	}
+	if config.attributes.Count > 0 {
+		printAttributeMetrics(snapshot)
+	}
+
+	// Print error counts by status code, 0 counts errors that got no HTTP response
+	for code, count := range snapshot.ErrorCounts {
+		if code == 0 {
+			summaryLog.Printf("Errors without HTTP response: %d", count)
+			continue
+		}
+		summaryLog.Printf("HTTP %d Errors: %d", code, count)
+	}
+
+	if hdrLog != nil {
+		writeHdrInterval()
+	}
+	if rawLatencyLog != nil {
+		flushRawLatencyLog()
+	}
+	if config.failuresOut != "" {
+		writeFailures(config.failuresOut)
+	}
+	registry.flush()
+}
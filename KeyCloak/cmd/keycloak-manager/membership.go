@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+const usersPageSize = 100
+
+// Remove random group memberships from the users already present in the realm
+func removeMemberships(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time, count int) error {
+	// Requests in flight finish after a shutdown signal, which is only checked between steps
+	shutdown, ctx := ctx, context.WithoutCancel(ctx)
+
+	userIDs, err := listUserIDs(ctx, client, token, realm)
+	if err != nil {
+		return fmt.Errorf("failed to list users: %v", err)
+	}
+	slog.Info("Found users to remove memberships from", "count", len(userIDs))
+	startProgress(count)
+
+	for removed := 0; removed < count && shutdown.Err() == nil; {
+		if len(userIDs) == 0 {
+			return fmt.Errorf("no users with group memberships left after removing %d of %d", removed, count)
+		}
+
+		token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+
+		userIdx := randomIndex(len(userIDs))
+		userID := userIDs[userIdx]
+		dropUser := func() {
+			userIDs[userIdx] = userIDs[len(userIDs)-1]
+			userIDs = userIDs[:len(userIDs)-1]
+		}
+
+		var groups []*gocloak.Group
+		err := runner.Retry(ctx, opGetUserGroups, func() error {
+			var err error
+			groups, err = client.GetUserGroups(ctx, token.AccessToken, realm, userID, gocloak.GetGroupsParams{})
+			return err
+		})
+		if err != nil {
+			// Errors left after the retries, like a 404 for a deleted user, won't go away
+			slog.Error("Failed to get groups of user", "id", userID, "err", err)
+			updateErrorMetrics(keycloakload.StatusFromError(err))
+			recordFailure(opGetUserGroups, userID, err)
+			dropUser()
+			continue
+		}
+		if len(groups) == 0 {
+			// Nothing left to remove for this user
+			dropUser()
+			continue
+		}
+
+		group := groups[randomIndex(len(groups))]
+
+		err = runner.Retry(ctx, opRemoveMembership, func() error {
+			startTime := time.Now()
+			err := client.DeleteUserFromGroup(ctx, token.AccessToken, realm, userID, *group.ID)
+			latency := time.Since(startTime)
+
+			// Update latency metrics
+			updateLatencyMetrics(opRemoveMembership, latency)
+			return err
+		})
+		if err != nil {
+			slog.Error("Failed to remove user from group", "id", userID, "group", *group.Path, "err", err)
+			updateErrorMetrics(keycloakload.StatusFromError(err))
+			recordFailure(opRemoveMembership, userID+" "+*group.Path, err)
+			dropUser()
+			continue
+		}
+		slog.Debug("Removed user from group", "id", userID, "group", *group.Path)
+		incrementMembershipRemovedCounter()
+		advanceProgress()
+		removed++
+	}
+
+	return nil
+}
+
+// List the IDs of all users in the realm
+func listUserIDs(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) ([]string, error) {
+	var userIDs []string
+	for first := 0; ; first += usersPageSize {
+		users, err := client.GetUsers(ctx, token.AccessToken, realm, gocloak.GetUsersParams{
+			BriefRepresentation: gocloak.BoolP(true),
+			First:               gocloak.IntP(first),
+			Max:                 gocloak.IntP(usersPageSize),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			userIDs = append(userIDs, *user.ID)
+		}
+		if len(users) < usersPageSize {
+			return userIDs, nil
+		}
+	}
+}
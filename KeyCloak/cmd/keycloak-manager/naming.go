@@ -0,0 +1,13 @@
+package main
+
+import "keycloak-manager/keycloakload"
+
+// Name of a generated top-level group, its subgroups are named after it
+func newGroupName(stamp int64) string {
+	return keycloakload.GroupName(config.prefix, stamp)
+}
+
+// Name of a generated user, the index tells the users of a subgroup apart
+func newUserName(stamp int64, userIdx int) string {
+	return keycloakload.StampUsername(config.prefix, stamp, userIdx)
+}
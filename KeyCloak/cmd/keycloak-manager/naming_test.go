@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestGeneratedNamesUnique(t *testing.T) {
+	const (
+		workers          = 8
+		groupsPerWorker  = 500
+		usersPerSubgroup = 3
+	)
+
+	saved := config
+	t.Cleanup(func() { config = saved })
+
+	for _, deterministic := range []bool{false, true} {
+		config.deterministic = deterministic
+		config.prefix = "test-"
+
+		var (
+			mu    sync.Mutex
+			wg    sync.WaitGroup
+			names = make(map[string]bool)
+		)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < groupsPerWorker; i++ {
+					generated := []string{newGroupName(nameStamp())}
+					for userIdx := 1; userIdx <= usersPerSubgroup; userIdx++ {
+						generated = append(generated, newUserName(nameStamp(), userIdx))
+					}
+
+					mu.Lock()
+					for _, name := range generated {
+						if names[name] {
+							t.Errorf("deterministic=%v: duplicate name %s", deterministic, name)
+						}
+						names[name] = true
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if want := workers * groupsPerWorker * (1 + usersPerSubgroup); len(names) != want {
+			t.Errorf("deterministic=%v: got %d unique names, want %d", deterministic, len(names), want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"math/big"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Length and alphabet of generated passwords, without characters that are easy to misread
+const (
+	randomPasswordLength   = 16
+	randomPasswordAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// Set the configured or a generated password on a created user, so it can actually log in.
+// Does nothing when neither -user-password nor -random-passwords is given.
+func setUserPassword(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, userID, userName string) {
+	password := config.userPassword
+	if config.randomPasswords {
+		password = randomPassword()
+	}
+	if password == "" {
+		return
+	}
+
+	if setPassword(ctx, client, token, realm, userID, userName, password, config.temporaryPassword) && config.randomPasswords {
+		slog.Info("Set password", "user", userName, "password", password)
+	}
+}
+
+// Set a password on a user, returning whether it was set
+func setPassword(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, userID, userName, password string, temporary bool) bool {
+	startTime := time.Now()
+	err := client.SetPassword(ctx, token.AccessToken, userID, realm, password, temporary)
+	latency := time.Since(startTime)
+
+	// Update latency metrics
+	updateLatencyMetrics(opSetPassword, latency)
+
+	if err != nil {
+		slog.Error("Failed to set password", "user", userName, "err", err)
+		updateErrorMetrics(keycloakload.StatusFromError(err))
+		recordFailure(opSetPassword, userName, err)
+		return false
+	}
+	return true
+}
+
+// Generate a password from a cryptographic source, passwords stay unpredictable even with -deterministic
+func randomPassword() string {
+	password := make([]byte, randomPasswordLength)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(randomPasswordAlphabet))))
+		if err != nil {
+			fatalf("Failed to generate password: %v", err)
+		}
+		password[i] = randomPasswordAlphabet[n.Int64()]
+	}
+	return string(password)
+}
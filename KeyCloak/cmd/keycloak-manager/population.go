@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Only users with this prefix after -prefix belong to the maintained population, so churn never deletes anyone else
+const populationPrefix = "Population-"
+
+// Print metrics after this many churn cycles
+const populationReportEvery = 100
+
+// Hold the realm at a steady population of users, deleting random users and creating replacements forever
+func maintainPopulation(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time, size int) error {
+	// Requests in flight finish after a shutdown signal, which is only checked between steps
+	shutdown, ctx := ctx, context.WithoutCancel(ctx)
+
+	userIDs, err := listPopulation(ctx, client, token, realm)
+	if err != nil {
+		return fmt.Errorf("failed to list population: %v", err)
+	}
+	slog.Info("Found population users", "count", len(userIDs), "size", size)
+
+	userIdx := 0
+	for cycle := 1; shutdown.Err() == nil; cycle++ {
+		token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+
+		if len(userIDs) >= size {
+			victim := randomIndex(len(userIDs))
+			if deletePopulationUser(ctx, client, token, realm, userIDs[victim]) {
+				userIDs[victim] = userIDs[len(userIDs)-1]
+				userIDs = userIDs[:len(userIDs)-1]
+			}
+		}
+
+		for len(userIDs) < size {
+			userIdx++
+			userID, ok := createPopulationUser(ctx, client, token, realm, userIdx)
+			if !ok {
+				// Leave the shortfall for the next cycle
+				break
+			}
+			userIDs = append(userIDs, userID)
+		}
+
+		if cycle%populationReportEvery == 0 {
+			printIntermediateMetrics()
+		}
+
+		sleepContext(shutdown, config.churnInterval)
+	}
+	return nil
+}
+
+// Create a population user with the -user-data generator like createSubgroupUser does, but outside
+// any group and named with the population prefix
+func createPopulationUser(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, userIdx int) (string, bool) {
+	stamp := nameStamp()
+	generated, err := generateUser(stamp, userIdx)
+	if err != nil {
+		return "", false
+	}
+	generated = generated.Renamed(fmt.Sprintf("%s%s%d-%d", config.prefix, populationPrefix, stamp, userIdx))
+	created, err := createGeneratedUser(ctx, realm, generated, keycloakload.Group{})
+	userID, userName := created.ID, created.Name
+	switch {
+	case err != nil || created.Outcome == keycloakload.OutcomeSkipped:
+		return "", false
+	case created.Outcome == keycloakload.OutcomeReused:
+		return userID, true
+	}
+	recordUngroupedUser(realm, userID, userName, stamp, userIdx)
+	setUserPassword(ctx, client, token, realm, userID, userName)
+	return userID, true
+}
+
+// Delete a population user, reporting whether it is gone from the realm
+func deletePopulationUser(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, userID string) bool {
+	startTime := time.Now()
+	err := client.DeleteUser(ctx, token.AccessToken, realm, userID)
+	latency := time.Since(startTime)
+
+	// Update latency metrics
+	updateLatencyMetrics(opDeleteUser, latency)
+
+	if err != nil && !keycloakload.IsNotFound(err) {
+		slog.Error("Failed to delete user", "id", userID, "err", err)
+		updateErrorMetrics(keycloakload.StatusFromError(err))
+		recordFailure(opDeleteUser, userID, err)
+		return false
+	}
+	slog.Debug("Deleted user", "id", userID)
+	incrementUserDeletedCounter()
+	return true
+}
+
+// List the IDs of all population users in the realm
+func listPopulation(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) ([]string, error) {
+	namePrefix := config.prefix + populationPrefix
+	var userIDs []string
+	for first := 0; ; first += usersPageSize {
+		users, err := client.GetUsers(ctx, token.AccessToken, realm, gocloak.GetUsersParams{
+			BriefRepresentation: gocloak.BoolP(true),
+			Search:              gocloak.StringP(namePrefix),
+			First:               gocloak.IntP(first),
+			Max:                 gocloak.IntP(usersPageSize),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			// Keycloak stores usernames in lower case, and search also matches e-mails and names
+			if strings.HasPrefix(*user.Username, strings.ToLower(namePrefix)) {
+				userIDs = append(userIDs, *user.ID)
+			}
+		}
+		if len(users) < usersPageSize {
+			return userIDs, nil
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// An admin capability probed with a minimal harmless operation before the run starts
+type capability struct {
+	name  string
+	probe func(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) error
+}
+
+var (
+	capCreateGroup = capability{name: "create group", probe: probeCreateGroup}
+	capCreateUser  = capability{name: "create user", probe: probeCreateUser}
+	capViewUsers   = capability{name: "view users", probe: probeViewUsers}
+	capViewGroups  = capability{name: "view groups", probe: probeViewGroups}
+	capCreateRole  = capability{name: "create realm role", probe: probeCreateRole}
+	capDelete      = capability{name: "delete users and groups", probe: probeDelete}
+)
+
+// Capabilities needed by the configured mode
+func requiredCapabilities() []capability {
+	if config.removeMemberships > 0 || config.maintainPopulation > 0 {
+		// Removing memberships and deleting users need manage-users, which creating a user also probes
+		return []capability{capViewUsers, capCreateUser}
+	}
+	if len(config.scenario) > 0 {
+		// Updating users needs manage-users, which creating a user also probes
+		return []capability{capViewUsers, capCreateUser}
+	}
+	if config.cleanup != "" || config.cleanupGenerated {
+		// Probed without deleting anything, so that a -dry-run leaves the realm as it is
+		return []capability{capViewUsers, capViewGroups, capDelete}
+	}
+	if config.assertSpec != "" || config.verify != "" {
+		return []capability{capViewUsers, capViewGroups}
+	}
+	if config.federatedUsers != "" {
+		return []capability{capCreateUser}
+	}
+
+	var capabilities []capability
+	if config.importFile == "" {
+		capabilities = append(capabilities, capCreateGroup)
+	}
+	capabilities = append(capabilities, capCreateUser)
+	// -user-roles creates the roles that don't exist yet
+	if config.groupRoles || len(config.userRoles) > 0 {
+		capabilities = append(capabilities, capCreateRole)
+	}
+	return capabilities
+}
+
+// Probe every capability the configured mode needs and fail with the full list of missing ones
+func preflight(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) error {
+	var missing []string
+	for _, c := range requiredCapabilities() {
+		if err := c.probe(ctx, client, token, realm); err != nil {
+			slog.Error("Preflight: missing permission", "to", c.name, "err", err)
+			missing = append(missing, c.name)
+			continue
+		}
+		slog.Debug("Preflight: permitted", "to", c.name)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("admin lacks %d required permission(s): %v", len(missing), missing)
+	}
+	return nil
+}
+
+func probeCreateGroup(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) error {
+	groupName := fmt.Sprintf("preflight-%d", time.Now().UnixNano())
+	groupID, err := client.CreateGroup(ctx, token.AccessToken, realm, gocloak.Group{Name: &groupName})
+	if err != nil {
+		return err
+	}
+	if err := client.DeleteGroup(ctx, token.AccessToken, realm, groupID); err != nil {
+		slog.Warn("Preflight: failed to delete probe group", "group", groupName, "err", err)
+	}
+	return nil
+}
+
+func probeCreateUser(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) error {
+	userName := fmt.Sprintf("preflight-%d", time.Now().UnixNano())
+	userID, err := client.CreateUser(ctx, token.AccessToken, realm, gocloak.User{Username: &userName})
+	if err != nil {
+		return err
+	}
+	if err := client.DeleteUser(ctx, token.AccessToken, realm, userID); err != nil {
+		slog.Warn("Preflight: failed to delete probe user", "user", userName, "err", err)
+	}
+	return nil
+}
+
+func probeCreateRole(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) error {
+	roleName := fmt.Sprintf("preflight-%d", time.Now().UnixNano())
+	if _, err := client.CreateRealmRole(ctx, token.AccessToken, realm, gocloak.Role{Name: &roleName}); err != nil {
+		return err
+	}
+	if err := client.DeleteRealmRole(ctx, token.AccessToken, realm, roleName); err != nil {
+		slog.Warn("Preflight: failed to delete probe role", "role", roleName, "err", err)
+	}
+	return nil
+}
+
+func probeViewUsers(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) error {
+	_, err := client.GetUsers(ctx, token.AccessToken, realm, gocloak.GetUsersParams{Max: gocloak.IntP(1)})
+	return err
+}
+
+func probeViewGroups(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) error {
+	_, err := client.GetGroups(ctx, token.AccessToken, realm, gocloak.GetGroupsParams{Max: gocloak.IntP(1)})
+	return err
+}
+
+// Deleting users and groups needs manage-users, which is looked up in the admin roles the admin
+// console sees instead of deleting a probe entity
+func probeDelete(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) error {
+	var whoAmI struct {
+		RealmAccess map[string][]string `json:"realm_access"`
+	}
+	resp, err := client.GetRequestWithBearerAuth(ctx, token.AccessToken).
+		SetResult(&whoAmI).
+		SetQueryParam("currentRealm", realm).
+		Get(config.url + "/admin/" + config.realm + "/console/whoami")
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return &gocloak.APIError{Code: resp.StatusCode(), Message: resp.String()}
+	}
+	if !slices.Contains(whoAmI.RealmAccess[realm], "manage-users") {
+		return errors.New("no manage-users role in the realm")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of past progress lines the completion rate is averaged over
+const progressRateWindow = 10
+
+var progress struct {
+	mu    sync.Mutex
+	total int
+	done  int
+}
+
+// Log a progress line with completion percentage and ETA every -progress-interval until total items are done
+func startProgress(total int) {
+	progress.mu.Lock()
+	progress.total = total
+	progress.done = 0
+	progress.mu.Unlock()
+
+	if config.progressInterval == 0 || total == 0 {
+		return
+	}
+
+	go func() {
+		type sample struct {
+			time time.Time
+			done int
+		}
+		samples := []sample{{time: time.Now()}}
+
+		ticker := time.NewTicker(config.progressInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			progress.mu.Lock()
+			done := progress.done
+			progress.mu.Unlock()
+
+			// Rolling completion rate, so the ETA follows changes in server speed
+			samples = append(samples, sample{time: now, done: done})
+			if len(samples) > progressRateWindow+1 {
+				samples = samples[1:]
+			}
+			oldest := samples[0]
+			rate := float64(done-oldest.done) / now.Sub(oldest.time).Seconds()
+
+			eta := "unknown"
+			if rate > 0 {
+				eta = (time.Duration(float64(total-done)/rate) * time.Second).Round(time.Second).String()
+			}
+			summaryLog.Printf("Progress: %.1f%% complete, %d remaining, ETA %s", float64(done)*100/float64(total), total-done, eta)
+
+			if done >= total {
+				return
+			}
+		}
+	}()
+}
+
+// Count one more item towards the progress total
+func advanceProgress() {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+
+	progress.done++
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"keycloak-manager/keycloakload"
+)
+
+// Serve the counters and latency histograms in Prometheus text format on addr, e.g. ":9100"
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writePrometheusMetrics(w)
+	})
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fatalf("Metrics endpoint failed: %v", err)
+		}
+	}()
+	slog.Info("Serving Prometheus metrics", "url", addr+"/metrics")
+}
+
+func writePrometheusMetrics(w io.Writer) {
+	snapshot := loadMetrics.Snapshot()
+	methods := methodStats()
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, c := range counters(snapshot) {
+		fmt.Fprintf(w, "# HELP keycloak_manager_%s %s\n# TYPE keycloak_manager_%s counter\nkeycloak_manager_%s %d\n",
+			c.name, c.help, c.name, c.name, c.value)
+	}
+
+	// Errors by status code, 0 counts errors that got no HTTP response
+	fmt.Fprintf(w, "# HELP keycloak_manager_errors_total Failed operations by HTTP status code.\n# TYPE keycloak_manager_errors_total counter\n")
+	codes := make([]int, 0, len(snapshot.ErrorCounts))
+	for code := range snapshot.ErrorCounts {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "keycloak_manager_errors_total{code=\"%d\"} %d\n", code, snapshot.ErrorCounts[code])
+	}
+
+	writeLatencyHistograms(w, "operation_latency_seconds", "Latency of Keycloak operations.", "operation", snapshot.Operations)
+	writeLatencyHistograms(w, "http_request_latency_seconds", "Latency of HTTP requests to Keycloak by method.", "method", methods)
+
+	fmt.Fprintf(w, "# HELP keycloak_manager_operation_errors_total Failed operations by operation, where counted separately.\n# TYPE keycloak_manager_operation_errors_total counter\n")
+	for _, op := range sortedKeys(snapshot.Operations) {
+		fmt.Fprintf(w, "keycloak_manager_operation_errors_total{operation=%q} %d\n", op, snapshot.Operations[op].Errors)
+	}
+	fmt.Fprintf(w, "# HELP keycloak_manager_http_errors_total Failed HTTP requests by method.\n# TYPE keycloak_manager_http_errors_total counter\n")
+	for _, method := range sortedKeys(methods) {
+		fmt.Fprintf(w, "keycloak_manager_http_errors_total{method=%q} %d\n", method, methods[method].Errors)
+	}
+}
+
+type counter struct {
+	name, help string
+	value      int
+}
+
+// The run's totals, shared by /metrics and -report. Must be called with mu held.
+func counters(snapshot keycloakload.MetricsSnapshot) []counter {
+	metrics.mu.Lock()
+	connectionResets := metrics.connectionResets
+	metrics.mu.Unlock()
+
+	return []counter{
+		{"groups_created_total", "Groups created.", totalGroupsCreated},
+		{"users_created_total", "Users created.", totalUsersCreated},
+		{"users_deleted_total", "Users deleted.", totalUsersDeleted},
+		{"memberships_removed_total", "Group memberships removed.", totalMembershipsRemoved},
+		{"users_registered_total", "Users registered through the registration form.", totalUsersRegistered},
+		{"name_collisions_total", "Creations that hit an existing name.", snapshot.Collisions},
+		{"users_upserted_total", "Existing users updated with their missing attributes by -upsert.", totalUsersUpserted},
+		{"roles_created_total", "Roles created.", totalRolesCreated},
+		{"user_roles_assigned_total", "Roles assigned to created users.", totalUserRoles},
+		{"retries_total", "Requests retried after a transient failure.", snapshot.Retries},
+		{"connection_resets_total", "Idempotent requests retried after a connection reset.", connectionResets},
+		{"failed_operations_total", "Failed operations, without conflicts.", snapshot.Errors},
+		{"conflicts_total", "Creations rejected with a 409 because the entity already exists.", snapshot.Conflicts},
+	}
+}
+
+func writeLatencyHistograms(w io.Writer, name, help, label string, all map[string]keycloakload.OperationStats) {
+	fmt.Fprintf(w, "# HELP keycloak_manager_%s %s\n# TYPE keycloak_manager_%s histogram\n", name, help, name)
+	for _, key := range sortedKeys(all) {
+		m := all[key]
+		cumulative := 0
+		for i, bound := range keycloakload.LatencyBuckets {
+			if m.Buckets != nil {
+				cumulative += m.Buckets[i]
+			}
+			fmt.Fprintf(w, "keycloak_manager_%s_bucket{%s=%q,le=\"%s\"} %d\n", name, label, key,
+				strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "keycloak_manager_%s_bucket{%s=%q,le=\"+Inf\"} %d\n", name, label, key, m.Count)
+		fmt.Fprintf(w, "keycloak_manager_%s_sum{%s=%q} %g\n", name, label, key, m.TotalLatency.Seconds())
+		fmt.Fprintf(w, "keycloak_manager_%s_count{%s=%q} %d\n", name, label, key, m.Count)
+	}
+}
+
+func sortedKeys[V any](all map[string]V) []string {
+	keys := make([]string, 0, len(all))
+	for key := range all {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/csv"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Per-request latency log written by -raw-latency-out, buffered by the CSV writer
+var rawLatencyLog *csv.Writer
+
+// Open the raw latency log and write its header
+func openRawLatencyLog(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	slog.Warn("Writing every request, expect this file to grow large on long runs", "file", path)
+
+	rawLatencyLog = csv.NewWriter(file)
+	return rawLatencyLog.Write([]string{"timestamp", "operation", "latency_ns", "status"})
+}
+
+// Log the latency of every request made through restyClient to the raw latency log
+func traceRawLatency(restyClient *resty.Client) {
+	restyClient.OnAfterResponse(func(c *resty.Client, resp *resty.Response) error {
+		writeRawLatency(resp.Request, resp.Time(), resp.StatusCode())
+		return nil
+	})
+	restyClient.OnError(func(req *resty.Request, err error) {
+		// Requests that got no response at all are logged with status 0
+		if _, ok := err.(*resty.ResponseError); !ok {
+			writeRawLatency(req, time.Since(req.Time), 0)
+		}
+	})
+}
+
+func writeRawLatency(req *resty.Request, latency time.Duration, status int) {
+	// The operation is the method and path, e.g. "POST /admin/realms/master/users"
+	path := req.URL
+	if u, err := url.Parse(req.URL); err == nil {
+		path = u.Path
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	err := rawLatencyLog.Write([]string{
+		req.Time.UTC().Format(time.RFC3339Nano),
+		req.Method + " " + path,
+		strconv.FormatInt(latency.Nanoseconds(), 10),
+		strconv.Itoa(status),
+	})
+	if err != nil {
+		slog.Error("Failed to write raw latency", "err", err)
+	}
+}
+
+// Write out the buffered raw latencies. Must be called with metrics.mu held.
+func flushRawLatencyLog() {
+	rawLatencyLog.Flush()
+	if err := rawLatencyLog.Error(); err != nil {
+		slog.Error("Failed to write raw latency log", "err", err)
+	}
+}
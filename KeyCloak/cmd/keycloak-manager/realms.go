@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Token lifetimes in seconds of realms created by -create-realms, the Keycloak defaults
+const (
+	realmAccessTokenLifespan = 300
+	realmSSOSessionIdle      = 1800
+	realmSSOSessionMax       = 36000
+)
+
+// Group trees created so far, for spreading them over the target realms
+var realmTurn atomic.Int64
+
+// Realms group trees are created in: -realms, or the login realm
+func targetRealms() []string {
+	if len(config.realms) > 0 {
+		return config.realms
+	}
+	return []string{config.realm}
+}
+
+// Realms the run creates in: group trees go in the target realms, imported users in the login realm,
+// and -resume completes group trees in whatever realms the checkpoint has them in
+func runRealms() []string {
+	realms := targetRealms()
+	addRealm := func(realm string) {
+		if !slices.Contains(realms, realm) {
+			realms = append(slices.Clip(realms), realm)
+		}
+	}
+	if config.importFile != "" {
+		addRealm(config.realm)
+	}
+	if config.resume {
+		for _, group := range registry.Groups {
+			if group.Realm != "" {
+				addRealm(group.Realm)
+			} else {
+				addRealm(config.realm)
+			}
+		}
+	}
+	return realms
+}
+
+// Realm for the next group tree, taking the target realms in turn
+func nextRealm() string {
+	realms := targetRealms()
+	return realms[(realmTurn.Add(1)-1)%int64(len(realms))]
+}
+
+// Create the target realms that don't exist yet
+func createRealms(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT) error {
+	for _, realm := range targetRealms() {
+		_, err := client.GetRealm(ctx, token.AccessToken, realm)
+		if err == nil {
+			slog.Info("Realm exists", "realm", realm)
+			continue
+		}
+		if !keycloakload.IsNotFound(err) {
+			return fmt.Errorf("failed to look up realm %s: %w", realm, err)
+		}
+
+		// Registration is on so that -register-users works in the new realm, and brute force
+		// detection is off so that a load test doesn't lock its own users out
+		_, err = client.CreateRealm(ctx, token.AccessToken, gocloak.RealmRepresentation{
+			Realm:                 gocloak.StringP(realm),
+			Enabled:               gocloak.BoolP(true),
+			RegistrationAllowed:   gocloak.BoolP(true),
+			AccessTokenLifespan:   gocloak.IntP(realmAccessTokenLifespan),
+			SsoSessionIdleTimeout: gocloak.IntP(realmSSOSessionIdle),
+			SsoSessionMaxLifespan: gocloak.IntP(realmSSOSessionMax),
+			BruteForceProtected:   gocloak.BoolP(false),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create realm %s: %w", realm, err)
+		}
+		slog.Info("Created realm", "realm", realm)
+	}
+	return nil
+}
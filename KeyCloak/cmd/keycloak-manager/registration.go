@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"strings"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/go-resty/resty/v2"
+)
+
+// Name prefix of the users registered through the form
+const registeredPrefix = "Registered-"
+
+var registerFormAction = regexp.MustCompile(`(?s)<form[^>]*id="kc-register-form"[^>]*action="([^"]+)"`)
+
+// Register users through the realm's self-registration form, as an anonymous browser would
+func registerUsers(ctx context.Context, client *gocloak.GoCloak, realm string, count int) error {
+	// Requests in flight finish after a shutdown signal, which is only checked between steps
+	shutdown, ctx := ctx, context.WithoutCancel(ctx)
+
+	startProgress(count)
+	for userIdx := 1; userIdx <= count && shutdown.Err() == nil; userIdx++ {
+		userName := fmt.Sprintf("%s%s%d-%d", config.prefix, registeredPrefix, nameStamp(), userIdx)
+
+		err := registerUser(ctx, client, realm, userName)
+		advanceProgress()
+		if err != nil {
+			slog.Error("Failed to register user", "user", userName, "err", err)
+			updateErrorMetrics(keycloakload.StatusFromError(err))
+			recordFailure(opRegisterUser, userName, err)
+			continue
+		}
+		slog.Debug("Registered user", "user", userName)
+		incrementUserRegisteredCounter()
+	}
+
+	return nil
+}
+
+// Load the registration form and submit it for a single user
+func registerUser(ctx context.Context, client *gocloak.GoCloak, realm, userName string) error {
+	// Every registration gets its own cookie jar so that auth sessions don't leak between users,
+	// and stops at the redirect back to the client instead of following it
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+	restyClient := resty.NewWithClient(&http.Client{
+		Transport: client.RestyClient().GetClient().Transport,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	})
+	restyClient.SetLogger(restyLogger{})
+	traceConnections(restyClient)
+	traceMethods(restyClient)
+	retryConnectionResets(restyClient)
+	if rawLatencyLog != nil {
+		traceRawLatency(restyClient)
+	}
+
+	challenge, err := codeChallenge()
+	if err != nil {
+		return err
+	}
+	accountURL := fmt.Sprintf("%s/realms/%s/account/", config.url, realm)
+
+	startTime := time.Now()
+	resp, err := restyClient.R().
+		SetContext(ctx).
+		SetQueryParams(map[string]string{
+			"client_id":             config.registrationClient,
+			"response_type":         "code",
+			"scope":                 "openid",
+			"redirect_uri":          accountURL,
+			"code_challenge":        challenge,
+			"code_challenge_method": "S256",
+		}).
+		Get(fmt.Sprintf("%s/realms/%s/protocol/openid-connect/registrations", config.url, realm))
+	latency := time.Since(startTime)
+
+	// Update latency metrics
+	updateLatencyMetrics(opRegistrationForm, latency)
+
+	if err != nil {
+		return fmt.Errorf("failed to load registration form: %v", err)
+	}
+	if resp.IsError() {
+		return &gocloak.APIError{Code: resp.StatusCode(), Message: "failed to load registration form: " + resp.Status()}
+	}
+	match := registerFormAction.FindSubmatch(resp.Body())
+	if match == nil {
+		return fmt.Errorf("no registration form found, is user registration enabled for realm %s?", realm)
+	}
+	action := html.UnescapeString(string(match[1]))
+
+	startTime = time.Now()
+	resp, err = restyClient.R().
+		SetContext(ctx).
+		SetFormData(map[string]string{
+			"username":         userName,
+			"email":            strings.ToLower(userName) + "@example.com",
+			"firstName":        "Registered",
+			"lastName":         userName,
+			"password":         config.registrationPassword,
+			"password-confirm": config.registrationPassword,
+		}).
+		Post(action)
+	latency = time.Since(startTime)
+
+	// Update latency metrics
+	updateLatencyMetrics(opRegisterUser, latency)
+
+	if err != nil {
+		return fmt.Errorf("failed to submit registration form: %v", err)
+	}
+	// A successful registration redirects back to the client, anything else re-renders the form with an error
+	if resp.StatusCode() != http.StatusFound || !strings.Contains(resp.Header().Get("Location"), "code=") {
+		return &gocloak.APIError{Code: resp.StatusCode(), Message: "registration rejected: " + resp.Status()}
+	}
+
+	return nil
+}
+
+// Generate a PKCE S256 code challenge, which clients such as account-console require
+func codeChallenge() (string, error) {
+	verifier := make([]byte, 32)
+	if _, err := rand.Read(verifier); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(base64.RawURLEncoding.EncodeToString(verifier)))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
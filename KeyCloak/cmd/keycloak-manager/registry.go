@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Rewrite the -output file at most this often while creation proceeds
+const registryFlushInterval = time.Second
+
+// Contents of the -output file: every group, subgroup and user created by the run.
+// Reused entities existed before the run and only hold what was created in them.
+type Registry struct {
+	mu        sync.Mutex
+	lastFlush time.Time
+
+	Realm  string           `json:"realm"`
+	Groups []*RegistryGroup `json:"groups"`
+	// Users created outside any group, by -scenario, -population and -federated-users
+	Users []RegistryUser `json:"users,omitempty"`
+}
+
+type RegistryGroup struct {
+	// Realm of the group when it isn't the registry's, with -realms
+	Realm     string              `json:"realm,omitempty"`
+	ID        string              `json:"id"`
+	Name      string              `json:"name"`
+	Reused    bool                `json:"reused,omitempty"`
+	SubGroups []*RegistrySubgroup `json:"subGroups"`
+}
+
+type RegistrySubgroup struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Reused bool   `json:"reused,omitempty"`
+	// Nested subgroups with -group-depth above 2, users are only created in the deepest ones
+	SubGroups []*RegistrySubgroup `json:"subGroups,omitempty"`
+	Users     []RegistryUser      `json:"users,omitempty"`
+}
+
+type RegistryUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+var registry Registry
+
+// Add a group to the registry, returns nil when no -output is written
+func (r *Registry) addGroup(realm, id, name string, reused bool) *RegistryGroup {
+	if config.output == "" {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	group := &RegistryGroup{ID: id, Name: name, Reused: reused}
+	if realm != config.realm {
+		group.Realm = realm
+	}
+	r.Groups = append(r.Groups, group)
+	r.flushThrottled()
+	return group
+}
+
+// Add a subgroup to a group, or below another subgroup when parent isn't nil
+func (r *Registry) addSubgroup(group *RegistryGroup, parent *RegistrySubgroup, id, name string, reused bool) *RegistrySubgroup {
+	if group == nil && parent == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subGroup := &RegistrySubgroup{ID: id, Name: name, Reused: reused}
+	if parent != nil {
+		parent.SubGroups = append(parent.SubGroups, subGroup)
+	} else {
+		group.SubGroups = append(group.SubGroups, subGroup)
+	}
+	r.flushThrottled()
+	return subGroup
+}
+
+func (r *Registry) addUser(subGroup *RegistrySubgroup, id, name string) {
+	if subGroup == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subGroup.Users = append(subGroup.Users, RegistryUser{ID: id, Name: name})
+	r.flushThrottled()
+}
+
+// Add a user that isn't a member of a generated subgroup
+func (r *Registry) addUngroupedUser(id, name string) {
+	if config.output == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Users = append(r.Users, RegistryUser{ID: id, Name: name})
+	r.flushThrottled()
+}
+
+// Must be called with r.mu held
+func (r *Registry) flushThrottled() {
+	if time.Since(r.lastFlush) >= registryFlushInterval {
+		r.write()
+	}
+}
+
+// Write the registry out now, a no-op when no -output is written
+func (r *Registry) flush() {
+	if config.output == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.write()
+}
+
+// Replace the -output file, so that a crash still leaves a complete record. Must be called with r.mu held.
+func (r *Registry) write() {
+	r.lastFlush = time.Now()
+	r.Realm = config.realm
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		slog.Error("Failed to encode created entities", "err", err)
+		return
+	}
+
+	tmpPath := config.output + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		slog.Error("Failed to write created entities", "err", err)
+		return
+	}
+	if err := os.Rename(tmpPath, config.output); err != nil {
+		slog.Error("Failed to write created entities", "err", err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// One line of a -record-out file. Template is the entity name with its generated number
+// replaced by {n}; subgroups share the number of the group they are created in. Users are
+// generated anew from their index, since -user-data names don't always contain the number.
+type RecordedOperation struct {
+	// Time since the start of the recorded run
+	Offset    time.Duration `json:"offset_ns"`
+	Operation string        `json:"operation"`
+	Template  string        `json:"template"`
+	// Line number of the group the entity was created in, 0 for top-level groups
+	Parent int `json:"parent,omitempty"`
+	// The group already existed, it is only recorded for the entities created in it
+	Reused bool `json:"reused,omitempty"`
+	// Index of a user in its subgroup
+	Index int `json:"index,omitempty"`
+}
+
+var recording struct {
+	mu        sync.Mutex
+	encoder   *json.Encoder
+	startTime time.Time
+	lines     int
+}
+
+// Open the file the operations of this run are recorded to
+func openRecording(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	recording.encoder = json.NewEncoder(file)
+	recording.startTime = time.Now()
+	return nil
+}
+
+// Record a created entity, returning its line number for the entities created in it.
+// Returns 0 when the run isn't recorded.
+func recordOperation(op, name string, stamp int64, parent int) int {
+	return writeRecord(RecordedOperation{Operation: op, Parent: parent}, name, stamp)
+}
+
+// Record a group that already existed, like recordOperation, so that what is created in it can be replayed
+func recordReuse(op, name string, stamp int64, parent int) int {
+	return writeRecord(RecordedOperation{Operation: op, Parent: parent, Reused: true}, name, stamp)
+}
+
+// Record a created user with its index in its subgroup
+func recordUser(name string, stamp int64, userIdx, parent int) int {
+	return writeRecord(RecordedOperation{Operation: opCreateUser, Parent: parent, Index: userIdx}, name, stamp)
+}
+
+func writeRecord(rec RecordedOperation, name string, stamp int64) int {
+	if recording.encoder == nil {
+		return 0
+	}
+
+	recording.mu.Lock()
+	defer recording.mu.Unlock()
+
+	rec.Offset = time.Since(recording.startTime)
+	rec.Template = strings.Replace(name, strconv.FormatInt(stamp, 10), "{n}", 1)
+	if err := recording.encoder.Encode(rec); err != nil {
+		slog.Error("Failed to record operation", "op", rec.Operation, "name", name, "err", err)
+		return 0
+	}
+	recording.lines++
+	return recording.lines
+}
+
+// An entity created during replay, looked up by the line it was recorded on
+type replayedEntity struct {
+	id    string
+	path  string
+	stamp int64
+}
+
+// Reproduce the operations of a recorded run with their original pacing
+func replay(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Requests in flight finish after a shutdown signal, which is only checked between steps
+	shutdown, ctx := ctx, context.WithoutCancel(ctx)
+
+	entities := make(map[int]replayedEntity)
+	replayStart := time.Now()
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		var rec RecordedOperation
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %v", lineNo, err)
+		}
+
+		if !sleepContext(shutdown, time.Until(replayStart.Add(rec.Offset))) {
+			return nil
+		}
+		token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+
+		parent, ok := entities[rec.Parent]
+		if rec.Parent != 0 && !ok {
+			slog.Warn("Skipping line, its parent wasn't created", "line", lineNo, "parent", rec.Parent)
+			continue
+		}
+
+		stamp := nameStamp()
+		if rec.Operation == opCreateSubgroup {
+			stamp = parent.stamp
+		}
+		name := strings.ReplaceAll(rec.Template, "{n}", strconv.FormatInt(stamp, 10))
+
+		entity := replayedEntity{path: keycloakload.GroupPath(name), stamp: stamp}
+		switch rec.Operation {
+		case opCreateGroup, opCreateSubgroup:
+			if rec.Operation == opCreateSubgroup {
+				entity.path = parent.path + keycloakload.GroupPath(name)
+			}
+			err = runner.Retry(ctx, rec.Operation, func() error {
+				if err := runner.WaitForRate(ctx); err != nil {
+					return err
+				}
+				startTime := time.Now()
+				var err error
+				if rec.Operation == opCreateGroup {
+					entity.id, err = client.CreateGroup(ctx, token.AccessToken, realm, gocloak.Group{Name: &name})
+				} else {
+					entity.id, err = client.CreateChildGroup(ctx, token.AccessToken, realm, parent.id, gocloak.Group{Name: &name})
+				}
+				// A reused group is only created for its subgroups and users, it wasn't load of the recorded run
+				if !rec.Reused {
+					// Update latency metrics
+					updateLatencyMetrics(rec.Operation, time.Since(startTime))
+				}
+				return err
+			})
+			if errors.Is(err, keycloakload.ErrStopped) {
+				return nil
+			}
+			if err != nil {
+				slog.Error("Failed to replay operation", "op", rec.Operation, "name", name, "err", err)
+				updateErrorMetrics(keycloakload.StatusFromError(err))
+				recordFailure(rec.Operation, name, err)
+				continue
+			}
+			if rec.Operation == opCreateGroup && !rec.Reused {
+				incrementGroupCounter()
+			}
+
+		case opCreateUser:
+			// Users outside any group, e.g. of -scenario and -population, have no parent path
+			var generated keycloakload.User
+			if generated, err = generateUser(stamp, rec.Index); err != nil {
+				continue
+			}
+			var created keycloakload.Created
+			created, err = createGeneratedUser(ctx, realm, generated, keycloakload.Group{Path: parent.path})
+			entity.id, name = created.ID, created.Name
+			if errors.Is(err, keycloakload.ErrStopped) {
+				return nil
+			}
+			if err != nil || created.Outcome == keycloakload.OutcomeSkipped {
+				continue
+			}
+			if created.Outcome == keycloakload.OutcomeCreated {
+				incrementUserCounter()
+			}
+
+		default:
+			return fmt.Errorf("line %d: unknown operation %q", lineNo, rec.Operation)
+		}
+		slog.Debug("Replayed operation", "op", rec.Operation, "name", name, "id", entity.id)
+		entities[lineNo] = entity
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Contents of the -report file
+type Report struct {
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	// Every flag with its effective value, secrets redacted
+	Config     map[string]string `json:"config"`
+	Totals     map[string]int    `json:"totals"`
+	Operations []ReportOperation `json:"operations"`
+	// Failed operations by HTTP status code, 0 for no response. 409s are in the conflicts total.
+	Errors     map[string]int `json:"errors"`
+	Throughput []ReportMinute `json:"throughput"`
+}
+
+// Latency of one operation in milliseconds
+type ReportOperation struct {
+	Operation string  `json:"operation"`
+	Count     int     `json:"count"`
+	Errors    int     `json:"errors"`
+	Avg       float64 `json:"avgMs"`
+	P50       float64 `json:"p50Ms"`
+	P95       float64 `json:"p95Ms"`
+	P99       float64 `json:"p99Ms"`
+	Max       float64 `json:"maxMs"`
+}
+
+type ReportMinute struct {
+	Minute     int `json:"minute"`
+	Operations int `json:"operations"`
+	Errors     int `json:"errors"`
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func buildReport() Report {
+	snapshot := loadMetrics.Snapshot()
+	mu.Lock()
+	defer mu.Unlock()
+
+	report := Report{
+		StartTime: snapshot.StartTime,
+		EndTime:   time.Now(),
+		Config:    make(map[string]string),
+		Totals:    make(map[string]int),
+		Errors:    make(map[string]int),
+	}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "<redacted>"
+		}
+		report.Config[f.Name] = value
+	})
+
+	for _, c := range counters(snapshot) {
+		report.Totals[c.name] = c.value
+	}
+
+	for _, op := range sortedKeys(snapshot.Operations) {
+		m := snapshot.Operations[op]
+		report.Operations = append(report.Operations, ReportOperation{
+			Operation: op,
+			Count:     m.Count,
+			Errors:    m.Errors,
+			Avg:       milliseconds(m.Average()),
+			P50:       milliseconds(m.P50),
+			P95:       milliseconds(m.P95),
+			P99:       milliseconds(m.P99),
+			Max:       milliseconds(m.PeakLatency),
+		})
+	}
+
+	for code, count := range snapshot.ErrorCounts {
+		report.Errors[strconv.Itoa(code)] = count
+	}
+
+	for minute, bucket := range snapshot.PerMinute {
+		report.Throughput = append(report.Throughput, ReportMinute{
+			Minute:     minute,
+			Operations: bucket.Operations,
+			Errors:     bucket.Errors,
+		})
+	}
+	return report
+}
+
+// Write the end-of-run report, as CSV for a .csv path and JSON otherwise
+func writeReport(path string) {
+	report := buildReport()
+
+	var data []byte
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		data = reportCSV(report)
+	} else {
+		var err error
+		data, err = json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			slog.Error("Failed to encode report", "err", err)
+			return
+		}
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		slog.Error("Failed to write report", "err", err)
+		return
+	}
+	slog.Info("Wrote report", "file", path)
+}
+
+// One "section,name,field,value" row per figure, so that runs can be diffed and joined
+func reportCSV(report Report) []byte {
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	row := func(section, name, field, value string) {
+		w.Write([]string{section, name, field, value})
+	}
+	float := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', 3, 64)
+	}
+
+	row("section", "name", "field", "value")
+	row("run", "", "start", report.StartTime.Format(time.RFC3339))
+	row("run", "", "end", report.EndTime.Format(time.RFC3339))
+	for _, name := range sortedKeys(report.Config) {
+		row("config", name, "value", report.Config[name])
+	}
+	for _, name := range sortedKeys(report.Totals) {
+		row("totals", name, "value", strconv.Itoa(report.Totals[name]))
+	}
+	for _, op := range report.Operations {
+		row("operation", op.Operation, "count", strconv.Itoa(op.Count))
+		row("operation", op.Operation, "errors", strconv.Itoa(op.Errors))
+		row("operation", op.Operation, "avg_ms", float(op.Avg))
+		row("operation", op.Operation, "p50_ms", float(op.P50))
+		row("operation", op.Operation, "p95_ms", float(op.P95))
+		row("operation", op.Operation, "p99_ms", float(op.P99))
+		row("operation", op.Operation, "max_ms", float(op.Max))
+	}
+	for _, code := range sortedKeys(report.Errors) {
+		row("errors", code, "count", strconv.Itoa(report.Errors[code]))
+	}
+	for _, minute := range report.Throughput {
+		row("throughput", strconv.Itoa(minute.Minute), "operations", strconv.Itoa(minute.Operations))
+		row("throughput", strconv.Itoa(minute.Minute), "errors", strconv.Itoa(minute.Errors))
+	}
+	w.Flush()
+	return []byte(sb.String())
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Load the -output file of an interrupted run into the registry, so that this run adds to it,
+// and restore the created group and user counters from it
+func loadCheckpoint(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if registry.Realm != "" && registry.Realm != config.realm {
+		return fmt.Errorf("%s was recorded in realm %s, not %s", path, registry.Realm, config.realm)
+	}
+
+	for _, group := range registry.Groups {
+		if !group.Reused {
+			totalGroupsCreated++
+		}
+		for _, subGroup := range group.SubGroups {
+			totalUsersCreated += recordedUsers(subGroup)
+		}
+	}
+	// Users of the interrupted run count towards -total-users
+	usersReserved.Store(int64(totalUsersCreated))
+	slog.Info("Resuming", "file", path, "groups", totalGroupsCreated, "users", totalUsersCreated)
+	return nil
+}
+
+func recordedUsers(subGroup *RegistrySubgroup) int {
+	users := len(subGroup.Users)
+	for _, child := range subGroup.SubGroups {
+		users += recordedUsers(child)
+	}
+	return users
+}
+
+// Complete the group trees of the checkpoint that an interrupted run left short of subgroups,
+// or, without -users-per-group-from-histogram, short of users
+func resumeGroupTrees(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time) (*gocloak.JWT, time.Time) {
+	for _, regGroup := range registry.Groups {
+		if ctx.Err() != nil {
+			break
+		}
+		if !treeIncomplete(regGroup.Name, 1, regGroup.SubGroups, 0) {
+			continue
+		}
+		groupRealm := realm
+		if regGroup.Realm != "" {
+			groupRealm = regGroup.Realm
+		}
+		token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+		token, expirationTime = resumeGroupTree(ctx, client, token, groupRealm, expirationTime, regGroup)
+	}
+	return token, expirationTime
+}
+
+func resumeGroupTree(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time, regGroup *RegistryGroup) (*gocloak.JWT, time.Time) {
+	// Requests in flight finish after a shutdown signal, which is only checked between subgroups
+	shutdown, ctx := ctx, context.WithoutCancel(ctx)
+	slog.Info("Resuming group", "group", regGroup.Name)
+
+	// The stamp of a generated name is the number after "Group-"
+	stampText, _, _ := strings.Cut(strings.TrimPrefix(regGroup.Name, config.prefix+"Group-"), "-")
+	stamp, _ := strconv.ParseInt(stampText, 10, 64)
+	tree := groupNode{
+		id:       regGroup.ID,
+		name:     regGroup.Name,
+		path:     keycloakload.GroupPath(regGroup.Name),
+		stamp:    stamp,
+		level:    1,
+		line:     recordReuse(opCreateGroup, regGroup.Name, stamp, 0),
+		regGroup: regGroup,
+	}
+
+	jobs, waitUsers := startUserWorkers(ctx, client, realm)
+	token, expirationTime, _ = fillSubgroups(ctx, shutdown, client, token, realm, expirationTime, jobs, tree)
+
+	// Let the workers finish the queued users
+	close(jobs)
+	if userErrs := waitUsers(); len(userErrs) > 0 {
+		slog.Error("Failed to create users resuming group", "group", regGroup.Name, "users", len(userErrs), "first", userErrs[0])
+	}
+	return token, expirationTime
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Create a realm role named after a group and map it to the group, so the hierarchy also exists in the role space.
+// A role left over from an earlier run is mapped as it is.
+func createGroupRole(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, groupID, roleName string) error {
+	startTime := time.Now()
+	_, err := client.CreateRealmRole(ctx, token.AccessToken, realm, gocloak.Role{Name: &roleName})
+	latency := time.Since(startTime)
+
+	// Update latency metrics
+	updateLatencyMetrics(opCreateRole, latency)
+
+	switch {
+	case err == nil:
+		slog.Debug("Created role", "role", roleName)
+		incrementRoleCounter()
+	case keycloakload.IsConflict(err):
+		slog.Debug("Reusing existing role", "role", roleName)
+	default:
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+
+	// Mapping needs the role ID, which creating the role doesn't return
+	role, err := client.GetRealmRole(ctx, token.AccessToken, realm, roleName)
+	if err != nil {
+		return fmt.Errorf("failed to get role: %w", err)
+	}
+
+	startTime = time.Now()
+	err = client.AddRealmRoleToGroup(ctx, token.AccessToken, realm, groupID, []gocloak.Role{*role})
+	latency = time.Since(startTime)
+
+	// Update latency metrics
+	updateLatencyMetrics(opMapGroupRole, latency)
+
+	if err != nil {
+		return fmt.Errorf("failed to map role to group: %w", err)
+	}
+	incrementRoleMappingCounter()
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Creates the groups, subgroups and users of the run, set up once logged in
+var runner *keycloakload.Runner
+
+// What the run breaks latency down by
+func metricsConfig() keycloakload.MetricsConfig {
+	return keycloakload.MetricsConfig{
+		MeasureOps:       config.measureOps,
+		DepthLatency:     config.groupDepthLatency,
+		AttributeLatency: config.attributes.Count > 0,
+	}
+}
+
+// Runner with the admin token of tokens, -rate, -max-retries and -name-collision-strategy.
+// Its rate waits end when the run stops.
+func newRunner(client *gocloak.GoCloak, tokens *keycloakload.TokenManager) *keycloakload.Runner {
+	runner, err := keycloakload.NewRunner(keycloakload.RunnerConfig{
+		Client:                client,
+		Tokens:                tokens,
+		Metrics:               loadMetrics,
+		Rate:                  config.rate,
+		MaxRetries:            config.maxRetries,
+		NoJitter:              config.deterministic,
+		NameCollisionStrategy: config.nameCollisionStrategy,
+		Stop:                  runCtx.Done(),
+	})
+	if err != nil {
+		fatalf("Invalid flags: %v", err)
+	}
+	return runner
+}
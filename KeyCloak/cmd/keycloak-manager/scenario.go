@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Operations a -scenario interleaves
+const (
+	scenarioGetUsers  = "get-users"
+	scenarioGetGroups = "get-groups"
+	scenarioLogin     = "login"
+	scenarioCreate    = "create"
+	scenarioUpdate    = "update"
+)
+
+var scenarioOps = []string{scenarioGetUsers, scenarioGetGroups, scenarioLogin, scenarioCreate, scenarioUpdate}
+
+// Latency metrics operation of each scenario operation
+var scenarioMetricsOps = map[string]string{
+	scenarioGetUsers:  opGetUsers,
+	scenarioGetGroups: opGetGroups,
+	scenarioLogin:     opUserLogin,
+	scenarioCreate:    opCreateUser,
+	scenarioUpdate:    opUpdateUser,
+}
+
+// Existing users sampled from the realm at the start for queries, updates and logins
+const scenarioSampleSize = 1000
+
+// Results per page of the scenario queries
+const scenarioPageSize = 20
+
+// Print metrics after this many scenario operations
+const scenarioReportEvery = 1000
+
+// An operation run by its weighted share of the scenario steps, parsed from -scenario
+type scenarioShare struct {
+	op     string
+	weight int
+}
+
+// Parse "operation=weight" entries, e.g. "get-users=60,login=20,create=15,update=5"
+func parseScenario(value string) ([]scenarioShare, error) {
+	var shares []scenarioShare
+	for _, entry := range strings.Split(value, ",") {
+		op, weightText, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("%q is not operation=weight", entry)
+		}
+		if !slices.Contains(scenarioOps, op) {
+			return nil, fmt.Errorf("unknown operation %q, must be one of %v", op, scenarioOps)
+		}
+		weight, err := strconv.Atoi(weightText)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid weight %q of operation %s", weightText, op)
+		}
+		shares = append(shares, scenarioShare{op: op, weight: weight})
+	}
+	return shares, nil
+}
+
+// Weight of op in the -scenario, 0 if it isn't part of it
+func scenarioWeight(op string) int {
+	for _, share := range config.scenario {
+		if share.op == op {
+			return share.weight
+		}
+	}
+	return 0
+}
+
+// A user the scenario queries, updates and, with a known password, logs in as
+type scenarioUser struct {
+	id       string
+	name     string
+	password string
+}
+
+// Users sampled from the realm and created by the scenario, shared by its workers
+var scenarioPool struct {
+	mu    sync.Mutex
+	users []scenarioUser
+	// Indexes into users of the ones with a password
+	withPassword []int
+}
+
+func addScenarioUser(user scenarioUser) {
+	scenarioPool.mu.Lock()
+	defer scenarioPool.mu.Unlock()
+
+	scenarioPool.users = append(scenarioPool.users, user)
+	if user.password != "" {
+		scenarioPool.withPassword = append(scenarioPool.withPassword, len(scenarioPool.users)-1)
+	}
+}
+
+// Random user of the pool, one with a password if withPassword is set
+func randomScenarioUser(withPassword bool) (scenarioUser, bool) {
+	scenarioPool.mu.Lock()
+	defer scenarioPool.mu.Unlock()
+
+	if withPassword {
+		if len(scenarioPool.withPassword) == 0 {
+			return scenarioUser{}, false
+		}
+		return scenarioPool.users[scenarioPool.withPassword[randomIndex(len(scenarioPool.withPassword))]], true
+	}
+	if len(scenarioPool.users) == 0 {
+		return scenarioUser{}, false
+	}
+	return scenarioPool.users[randomIndex(len(scenarioPool.users))], true
+}
+
+// Next scenario operation, chosen by weight
+func pickScenarioOp() string {
+	totalWeight := 0
+	for _, share := range config.scenario {
+		totalWeight += share.weight
+	}
+	n := randomIndex(totalWeight)
+	for _, share := range config.scenario {
+		if n < share.weight {
+			return share.op
+		}
+		n -= share.weight
+	}
+	return config.scenario[len(config.scenario)-1].op
+}
+
+// Run the weighted operations of the -scenario against realm with -concurrency workers until stopped,
+// to simulate realistic traffic against a populated realm
+func runScenario(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) error {
+	// Requests in flight finish after a shutdown signal, which is only checked between steps
+	shutdown, ctx := ctx, context.WithoutCancel(ctx)
+
+	// Only generated users are sampled, so that updates never touch real users or the admin.
+	// They can only be logged in as with the -user-password they were created with.
+	users, err := listGeneratedUsers(ctx, client, token, realm, scenarioSampleSize)
+	if err != nil {
+		return fmt.Errorf("failed to sample users: %v", err)
+	}
+	for _, user := range users {
+		addScenarioUser(scenarioUser{id: *user.ID, name: *user.Username, password: config.userPassword})
+	}
+	slog.Info("Scenario sampled users", "count", len(users), "realm", realm)
+	if scenarioWeight(scenarioLogin) > 0 && config.userPassword == "" && scenarioWeight(scenarioCreate) == 0 {
+		return fmt.Errorf("login needs -user-password for the existing users or create in the scenario")
+	}
+
+	var steps atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < config.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shutdown.Err() == nil {
+				token, _ := sharedToken(ctx, client)
+				runScenarioOp(ctx, client, token, realm, pickScenarioOp())
+				if steps.Add(1)%scenarioReportEvery == 0 {
+					printIntermediateMetrics()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// Run a single scenario operation, measured as its own operation
+func runScenarioOp(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, op string) {
+	var call func() error
+	switch op {
+	case scenarioGetUsers:
+		// Search by the start of a known username, so that the query has hits to return
+		params := gocloak.GetUsersParams{Max: gocloak.IntP(scenarioPageSize)}
+		if user, ok := randomScenarioUser(false); ok {
+			search := user.name[:min(3, len(user.name))]
+			params.Search = &search
+		}
+		call = func() error {
+			_, err := client.GetUsers(ctx, token.AccessToken, realm, params)
+			return err
+		}
+
+	case scenarioGetGroups:
+		search := config.prefix + "Group-"
+		call = func() error {
+			_, err := client.GetGroups(ctx, token.AccessToken, realm, gocloak.GetGroupsParams{
+				Search: &search,
+				Max:    gocloak.IntP(scenarioPageSize),
+			})
+			return err
+		}
+
+	case scenarioLogin:
+		user, ok := randomScenarioUser(true)
+		if !ok {
+			// No created user has a password yet
+			return
+		}
+		call = func() error {
+			_, err := client.Login(ctx, adminClientID, "", realm, user.name, user.password)
+			return err
+		}
+
+	case scenarioCreate:
+		createScenarioUser(ctx, client, realm)
+		return
+
+	case scenarioUpdate:
+		user, ok := randomScenarioUser(false)
+		if !ok {
+			return
+		}
+		first, last := userGenerator.RandomName()
+		call = func() error {
+			return client.UpdateUser(ctx, token.AccessToken, realm, gocloak.User{
+				ID:        &user.id,
+				Username:  &user.name,
+				FirstName: &first,
+				LastName:  &last,
+			})
+		}
+	}
+
+	if err := measureScenarioOp(op, call); err != nil {
+		slog.Error("Scenario operation failed", "op", op, "err", err)
+	}
+}
+
+func measureScenarioOp(op string, call func() error) error {
+	startTime := time.Now()
+	err := call()
+	latency := time.Since(startTime)
+
+	updateLatencyMetrics(scenarioMetricsOps[op], latency)
+	if err != nil {
+		updateOperationErrorMetrics(scenarioMetricsOps[op], keycloakload.StatusFromError(err))
+	}
+	return err
+}
+
+// Create a user with the -user-data generator like createSubgroupUser does, but outside any group,
+// give it a password and add it to the pool for logins
+func createScenarioUser(ctx context.Context, client *gocloak.GoCloak, realm string) {
+	token, _ := sharedToken(ctx, client)
+
+	stamp := nameStamp()
+	generated, err := generateUser(stamp, 1)
+	if err != nil {
+		return
+	}
+	created, err := createGeneratedUser(ctx, realm, generated, keycloakload.Group{})
+	userID, userName := created.ID, created.Name
+	switch {
+	case err != nil || created.Outcome == keycloakload.OutcomeSkipped:
+		return
+	case created.Outcome == keycloakload.OutcomeReused:
+		addScenarioUser(scenarioUser{id: userID, name: userName, password: config.userPassword})
+		return
+	}
+	recordUngroupedUser(realm, userID, userName, stamp, 1)
+
+	password := config.userPassword
+	if password == "" {
+		password = randomPassword()
+	}
+	// Users with pending required actions can't log in with a password grant
+	if len(config.requiredActions) > 0 || !setPassword(ctx, client, token, realm, userID, userName, password, false) {
+		password = ""
+	}
+	addScenarioUser(scenarioUser{id: userID, name: userName, password: password})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Context of the whole run, cancelled by a shutdown signal or a run limit. Modes detach their
+// requests from it so that they finish, waits that would hold up the shutdown use it instead.
+var runCtx = context.Background()
+
+// Sleep for d, returning false early if ctx is cancelled by a shutdown signal
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	// A ready timer would win the select half the time
+	if ctx.Err() != nil {
+		return false
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+var failRunOnce sync.Once
+
+// Log why the run can't go on and exit non-zero after the final metrics and -report. Workers
+// failing at the same time wait for the first one to exit.
+func failRun(format string, args ...any) {
+	failRunOnce.Do(func() {
+		slog.Error(fmt.Sprintf(format, args...))
+		finish(runCtx)
+		os.Exit(1)
+	})
+}
+
+// Print the final metrics and, when a signal stopped the run or it breached an error
+// threshold, exit non-zero
+func finish(ctx context.Context) {
+	printMetrics()
+	if config.report != "" {
+		writeReport(config.report)
+	}
+
+	// Thresholds are checked once more over the whole run
+	breach := errorLimitBreach()
+
+	var limit *runLimit
+	switch {
+	case errors.As(context.Cause(ctx), &limit) && limit.breached:
+		slog.Error("Failed: " + limit.reason)
+		os.Exit(1)
+	case breach != "":
+		slog.Error("Failed: " + breach)
+		os.Exit(1)
+	case limit != nil:
+		slog.Warn("Stopped: " + limit.reason)
+	case ctx.Err() != nil:
+		slog.Warn("Stopped by signal")
+		os.Exit(1)
+	}
+}
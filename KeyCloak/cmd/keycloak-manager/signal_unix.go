@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Print metrics whenever the process receives SIGUSR2, without disturbing the run
+func handleSummarySignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	go func() {
+		for range signals {
+			slog.Info("Received SIGUSR2, printing metrics")
+			printMetrics()
+		}
+	}()
+	slog.Info("Send SIGUSR2 (kill -USR2 <pid>) to print metrics immediately", "pid", os.Getpid())
+}
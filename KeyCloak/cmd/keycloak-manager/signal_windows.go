@@ -0,0 +1,12 @@
+//go:build windows
+
+package main
+
+import (
+	"log/slog"
+)
+
+// Windows has no SIGUSR2, so metrics are only printed after each run
+func handleSummarySignal() {
+	slog.Warn("Printing metrics on signal is not supported on Windows")
+}
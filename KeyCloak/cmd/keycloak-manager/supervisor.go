@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/go-resty/resty/v2"
+)
+
+// Pause between readiness polls while Keycloak is down
+const readinessPollInterval = 5 * time.Second
+
+// Start of the current run of requests that all failed without a response or with a 5xx,
+// zero while Keycloak answers
+var outage struct {
+	mu           sync.Mutex
+	failingSince time.Time
+}
+
+// Track whether requests made through restyClient are reaching a working Keycloak
+func traceOutages(restyClient *resty.Client) {
+	restyClient.OnAfterResponse(func(c *resty.Client, resp *resty.Response) error {
+		updateOutage(resp.StatusCode() < http.StatusInternalServerError)
+		return nil
+	})
+	restyClient.OnError(func(req *resty.Request, err error) {
+		// Requests that got no response at all
+		if _, ok := err.(*resty.ResponseError); !ok {
+			updateOutage(false)
+		}
+	})
+}
+
+func updateOutage(answered bool) {
+	outage.mu.Lock()
+	defer outage.mu.Unlock()
+
+	switch {
+	case answered:
+		outage.failingSince = time.Time{}
+	case outage.failingSince.IsZero():
+		outage.failingSince = time.Now()
+	}
+}
+
+// If every request has failed for longer than -outage-threshold, wait until Keycloak is ready
+// again and log in afresh, so that the run resumes instead of failing through the outage
+func waitOutOutage(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, expirationTime time.Time) (*gocloak.JWT, time.Time) {
+	outage.mu.Lock()
+	failingSince := outage.failingSince
+	outage.mu.Unlock()
+
+	if config.outageThreshold == 0 || failingSince.IsZero() || time.Since(failingSince) < config.outageThreshold {
+		return token, expirationTime
+	}
+
+	slog.Warn("Every request failed, pausing until Keycloak is ready", "since", failingSince.Format(time.RFC3339))
+	for {
+		// The realm's issuer endpoint is anonymous and only answers once Keycloak is up
+		if _, err := client.GetIssuer(ctx, config.realm); err == nil {
+			break
+		}
+		// ctx is detached from the shutdown signal so that requests finish, the wait shouldn't be
+		if !sleepContext(runCtx, readinessPollInterval) {
+			return token, expirationTime
+		}
+	}
+	slog.Info("Keycloak is back, resuming", "outage", time.Since(failingSince).Round(time.Second))
+
+	// Sessions may not have survived a restart, so don't wait for the token to expire
+	return freshToken(ctx, client, token, expirationTime)
+}
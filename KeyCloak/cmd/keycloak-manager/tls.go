@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Whether any of the TLS flags is set
+func customTLS() bool {
+	return config.caFile != "" || config.tlsCertFile != "" || config.insecureSkipVerify
+}
+
+// Make restyClient trust the -ca-file bundle on top of the system roots, present the
+// -tls-cert certificate to servers that require mutual TLS, and skip verification with
+// -insecure-skip-verify. Clients built on its transport, like the registration one, follow.
+func configureTLS(restyClient *resty.Client) error {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.insecureSkipVerify}
+
+	if config.caFile != "" {
+		data, err := os.ReadFile(config.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no PEM certificates found in %s", config.caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.tlsCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.tlsCertFile, config.tlsKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.insecureSkipVerify {
+		slog.Warn("TLS certificate verification is disabled, the connection to Keycloak can be intercepted")
+	}
+	restyClient.SetTLSClientConfig(tlsConfig)
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Admin token of the run, set once logged in
+var tokens *keycloakload.TokenManager
+
+// Keeps workers from waiting out the same outage side by side
+var outageWaitMu sync.Mutex
+
+// Manager of the admin token shared by every goroutine of the run, starting from the token of
+// the initial login. Replacements come from replaceToken, so that they honour -token and show up
+// in the token_refresh metrics.
+func newTokenManager(client *gocloak.GoCloak, token *gocloak.JWT, expirationTime time.Time) *keycloakload.TokenManager {
+	manager := keycloakload.NewTokenManager(func(ctx context.Context, current *gocloak.JWT, expires time.Time) (*gocloak.JWT, time.Time, error) {
+		return replaceToken(ctx, client, current, expires)
+	})
+	manager.Set(token, expirationTime)
+	return manager
+}
+
+// Current shared token and its expiry, refreshed first if it is about to expire.
+// Waits out a Keycloak outage first when -outage-threshold is set.
+func sharedToken(ctx context.Context, client *gocloak.GoCloak) (*gocloak.JWT, time.Time) {
+	outageWaitMu.Lock()
+	current, expirationTime := tokens.Current()
+	if token, newExpirationTime := waitOutOutage(ctx, client, current, expirationTime); token != current {
+		tokens.Set(token, newExpirationTime)
+	}
+	outageWaitMu.Unlock()
+
+	token, expirationTime, err := tokens.Token(ctx)
+	if err != nil {
+		failRun("Failed to reauthenticate: %v", err)
+	}
+	return token, expirationTime
+}
@@ -0,0 +1,53 @@
+package main
+
+import "sync/atomic"
+
+// Users queued for creation so far, counted against -total-users
+var usersReserved atomic.Int64
+
+// Number of subgroups each group at level-1 gets, with top-level groups at level 1
+func subgroupsAt(level int) int {
+	if len(config.fanOut) > 0 {
+		return config.fanOut[level-2]
+	}
+	return config.subgroups
+}
+
+// Reserve up to n more users under -total-users, returning how many may be created
+func reserveUsers(n int) int {
+	if config.totalUsers == 0 {
+		return n
+	}
+	for {
+		reserved := usersReserved.Load()
+		granted := min(int64(n), max(int64(config.totalUsers)-reserved, 0))
+		if usersReserved.CompareAndSwap(reserved, reserved+granted) {
+			return int(granted)
+		}
+	}
+}
+
+// Whether -total-users users have been queued, created or not, so that no further groups are needed
+func usersExhausted() bool {
+	return config.totalUsers > 0 && usersReserved.Load() >= int64(config.totalUsers)
+}
+
+// Whether a recorded group at level is missing subgroups below it or, without
+// -users-per-group-from-histogram, leaf subgroups short of users
+func treeIncomplete(name string, level int, subGroups []*RegistrySubgroup, users int) bool {
+	if level == config.groupDepth {
+		return config.groupSizeHistogram == "" && users < config.usersPerSubgroup
+	}
+
+	byName := make(map[string]*RegistrySubgroup, len(subGroups))
+	for _, subGroup := range subGroups {
+		byName[subGroup.Name] = subGroup
+	}
+	for subGrpIdx := 1; subGrpIdx <= subgroupsAt(level+1); subGrpIdx++ {
+		subGroup, ok := byName[subgroupName(name, subGrpIdx)]
+		if !ok || treeIncomplete(subGroup.Name, level+1, subGroup.SubGroups, len(subGroup.Users)) {
+			return true
+		}
+	}
+	return false
+}
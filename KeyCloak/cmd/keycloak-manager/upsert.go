@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Bring an existing user in line with the one a rerun would have created: fill in the names,
+// e-mail address and attributes it is missing, leaving what it has alone.
+// Group memberships are added by the callers, which know the group IDs.
+func upsertUser(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, userID string, want gocloak.User) error {
+	existing, err := client.GetUserByID(ctx, token.AccessToken, realm, userID)
+	if err != nil {
+		return err
+	}
+	if !mergeMissing(existing, want) {
+		return nil
+	}
+
+	err = runner.Retry(ctx, opUpdateUser, func() error {
+		if err := runner.WaitForRate(ctx); err != nil {
+			return err
+		}
+		startTime := time.Now()
+		err := client.UpdateUser(ctx, token.AccessToken, realm, *existing)
+		updateLatencyMetrics(opUpdateUser, time.Since(startTime))
+		return err
+	})
+	if err == nil {
+		incrementUpsertCounter()
+	}
+	return err
+}
+
+// Copy into user what it is missing from want, reporting whether anything changed
+func mergeMissing(user *gocloak.User, want gocloak.User) bool {
+	changed := false
+	for _, field := range []struct{ have, want **string }{
+		{&user.FirstName, &want.FirstName},
+		{&user.LastName, &want.LastName},
+		{&user.Email, &want.Email},
+	} {
+		if (*field.have == nil || **field.have == "") && *field.want != nil && **field.want != "" {
+			*field.have = *field.want
+			changed = true
+		}
+	}
+
+	if want.Attributes == nil {
+		return changed
+	}
+	if user.Attributes == nil {
+		user.Attributes = &map[string][]string{}
+	}
+	// Only absent attributes are set, generated values are random and would pile up with every rerun
+	attributes := *user.Attributes
+	for key, values := range *want.Attributes {
+		if len(attributes[key]) == 0 && len(values) > 0 {
+			attributes[key] = values
+			changed = true
+		}
+	}
+	return changed
+}
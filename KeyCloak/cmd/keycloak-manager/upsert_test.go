@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+func TestMergeMissing(t *testing.T) {
+	user := &gocloak.User{
+		FirstName:  gocloak.StringP("Jane"),
+		LastName:   gocloak.StringP(""),
+		Attributes: &map[string][]string{"department": {"Sales"}, "team": {"a"}},
+	}
+	want := gocloak.User{
+		FirstName:  gocloak.StringP("Janet"),
+		LastName:   gocloak.StringP("Doe"),
+		Email:      gocloak.StringP("jane.doe@example.com"),
+		Attributes: &map[string][]string{"department": {"Marketing"}, "locale": {"en"}, "team": {"a"}},
+	}
+
+	if !mergeMissing(user, want) {
+		t.Fatal("mergeMissing() = false, want a change")
+	}
+	if *user.FirstName != "Jane" || *user.LastName != "Doe" || *user.Email != "jane.doe@example.com" {
+		t.Errorf("names = %q %q <%q>, want Jane Doe <jane.doe@example.com>", *user.FirstName, *user.LastName, *user.Email)
+	}
+	wantAttributes := map[string][]string{"department": {"Sales"}, "locale": {"en"}, "team": {"a"}}
+	if !reflect.DeepEqual(*user.Attributes, wantAttributes) {
+		t.Errorf("attributes = %v, want %v", *user.Attributes, wantAttributes)
+	}
+
+	// An up-to-date user is left alone
+	if mergeMissing(user, want) {
+		t.Error("mergeMissing() of a merged user = true, want no change")
+	}
+}
+
+func TestMergeMissingWithoutAttributes(t *testing.T) {
+	user := &gocloak.User{}
+	want := gocloak.User{Attributes: &map[string][]string{"locale": {"de"}}}
+
+	if !mergeMissing(user, want) || !reflect.DeepEqual(*user.Attributes, map[string][]string{"locale": {"de"}}) {
+		t.Errorf("attributes = %v, want locale de", user.Attributes)
+	}
+	if mergeMissing(&gocloak.User{}, gocloak.User{}) {
+		t.Error("mergeMissing() of empty users = true, want no change")
+	}
+}
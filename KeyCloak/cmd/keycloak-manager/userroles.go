@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// A role given to a share of the created users, parsed from -user-roles
+type userRoleShare struct {
+	// Client ID of a client role, empty for a realm role
+	clientID string
+	name     string
+	weight   int
+
+	// Looked up or created by setupUserRoles in every realm users are created in
+	roles       map[string]*gocloak.Role
+	idsOfClient map[string]string
+}
+
+func (s *userRoleShare) String() string {
+	if s.clientID == "" {
+		return s.name
+	}
+	return s.clientID + "/" + s.name
+}
+
+// Parse "role=weight" entries, e.g. "viewer=80,admin=20", where "client/role" names a client role
+func parseUserRoles(value string) ([]*userRoleShare, error) {
+	var shares []*userRoleShare
+	for _, entry := range strings.Split(value, ",") {
+		name, weightText, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("%q is not role=weight", entry)
+		}
+		weight, err := strconv.Atoi(weightText)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid weight %q of role %s", weightText, name)
+		}
+
+		share := &userRoleShare{name: name, weight: weight}
+		if clientID, roleName, ok := strings.Cut(name, "/"); ok {
+			share.clientID, share.name = clientID, roleName
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// Look up the -user-roles in realm, creating the ones that don't exist yet
+func setupUserRoles(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string) error {
+	for _, share := range config.userRoles {
+		if share.roles == nil {
+			share.roles = make(map[string]*gocloak.Role)
+			share.idsOfClient = make(map[string]string)
+		}
+
+		if share.clientID != "" {
+			clients, err := client.GetClients(ctx, token.AccessToken, realm, gocloak.GetClientsParams{ClientID: &share.clientID})
+			if err != nil {
+				return fmt.Errorf("failed to look up client %s: %w", share.clientID, err)
+			}
+			if len(clients) == 0 {
+				return fmt.Errorf("client %s not found", share.clientID)
+			}
+			share.idsOfClient[realm] = *clients[0].ID
+		}
+
+		role, err := getUserRole(ctx, client, token, realm, share)
+		if keycloakload.IsNotFound(err) {
+			startTime := time.Now()
+			if share.clientID != "" {
+				_, err = client.CreateClientRole(ctx, token.AccessToken, realm, share.idsOfClient[realm], gocloak.Role{Name: &share.name})
+			} else {
+				_, err = client.CreateRealmRole(ctx, token.AccessToken, realm, gocloak.Role{Name: &share.name})
+			}
+			latency := time.Since(startTime)
+
+			// Update latency metrics
+			updateLatencyMetrics(opCreateRole, latency)
+
+			if err != nil {
+				return fmt.Errorf("failed to create role %s: %w", share, err)
+			}
+			slog.Info("Created role", "role", share, "realm", realm)
+			incrementRoleCounter()
+
+			// Assigning needs the role ID, which creating the role doesn't return
+			role, err = getUserRole(ctx, client, token, realm, share)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get role %s: %w", share, err)
+		}
+		share.roles[realm] = role
+	}
+	return nil
+}
+
+func getUserRole(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, share *userRoleShare) (*gocloak.Role, error) {
+	if share.clientID != "" {
+		return client.GetClientRole(ctx, token.AccessToken, realm, share.idsOfClient[realm], share.name)
+	}
+	return client.GetRealmRole(ctx, token.AccessToken, realm, share.name)
+}
+
+// Assign a created user one of the -user-roles, chosen by weight. Does nothing without -user-roles.
+func assignUserRole(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, userID, userName string) {
+	if len(config.userRoles) == 0 {
+		return
+	}
+
+	totalWeight := 0
+	for _, share := range config.userRoles {
+		totalWeight += share.weight
+	}
+	rngMu.Lock()
+	n := rng.Intn(totalWeight)
+	rngMu.Unlock()
+	var share *userRoleShare
+	for _, share = range config.userRoles {
+		if n < share.weight {
+			break
+		}
+		n -= share.weight
+	}
+
+	startTime := time.Now()
+	var err error
+	if share.clientID != "" {
+		err = client.AddClientRolesToUser(ctx, token.AccessToken, realm, share.idsOfClient[realm], userID, []gocloak.Role{*share.roles[realm]})
+	} else {
+		err = client.AddRealmRoleToUser(ctx, token.AccessToken, realm, userID, []gocloak.Role{*share.roles[realm]})
+	}
+	latency := time.Since(startTime)
+
+	// Update latency metrics
+	updateLatencyMetrics(opAssignUserRole, latency)
+
+	if err != nil {
+		slog.Error("Failed to assign role", "role", share, "user", userName, "err", err)
+		updateErrorMetrics(keycloakload.StatusFromError(err))
+		recordFailure(opAssignUserRole, userName, err)
+		return
+	}
+	incrementUserRoleCounter()
+}
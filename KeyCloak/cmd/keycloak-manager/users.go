@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// A user to create in a subgroup
+type userJob struct {
+	userIdx     int
+	subGrpID    string
+	subGrpPath  string
+	subGrpLine  int
+	regSubgroup *RegistrySubgroup
+}
+
+// Start -concurrency workers creating the users queued on the returned channel.
+// Close the channel once all users are queued, then wait for the errors of the failed ones.
+func startUserWorkers(ctx context.Context, client *gocloak.GoCloak, realm string) (chan<- userJob, func() []error) {
+	jobs := make(chan userJob)
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for i := 0; i < config.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if err := createSubgroupUser(ctx, client, realm, job); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	return jobs, func() []error {
+		wg.Wait()
+		return errs
+	}
+}
+
+// Create a user straight into its subgroup, the failure is logged and counted before it is returned
+func createSubgroupUser(ctx context.Context, client *gocloak.GoCloak, realm string, job userJob) error {
+	defer advanceProgress()
+	// Jobs wait in the queue, so the token is taken when the user is created
+	token, _ := sharedToken(ctx, client)
+
+	userStamp := nameStamp()
+	generated, err := generateUser(userStamp, job.userIdx)
+	if err != nil {
+		return err
+	}
+	attributeSize := keycloakload.AttributeSize(generated.Attributes)
+	// Users are created straight into their leaf subgroup
+	created, err := createGeneratedUser(ctx, realm, generated, keycloakload.Group{ID: job.subGrpID, Path: job.subGrpPath, Level: config.groupDepth})
+	userID, userName := created.ID, created.Name
+	if errors.Is(err, keycloakload.ErrStopped) {
+		// The subgroup is left short of the user, -resume fills it up
+		slog.Debug("Not creating user, the run stopped", "user", userName, "group", job.subGrpPath)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch created.Outcome {
+	case keycloakload.OutcomeSkipped:
+		return nil
+	case keycloakload.OutcomeReused:
+		if config.upsert {
+			err := upsertUser(ctx, client, token, realm, userID, generated.Representation(userName))
+			if errors.Is(err, keycloakload.ErrStopped) {
+				return nil
+			}
+			if err != nil {
+				slog.Error("Failed to update existing user", "user", userName, "err", err)
+				updateErrorMetrics(keycloakload.StatusFromError(err))
+				recordFailure(opUpdateUser, userName, err)
+				return fmt.Errorf("user %s: %w", userName, err)
+			}
+		}
+		// The existing user may not be a member yet
+		if err := client.AddUserToGroup(ctx, token.AccessToken, realm, userID, job.subGrpID); err != nil {
+			slog.Error("Failed to add existing user to group", "user", userName, "group", job.subGrpPath, "err", err)
+			updateErrorMetrics(keycloakload.StatusFromError(err))
+			recordFailure(opAddMembership, userName, err)
+			return fmt.Errorf("user %s: %w", userName, err)
+		}
+		slog.Debug("Reusing existing user", "user", userName, "id", userID)
+	default:
+		slog.Debug("Created user", "user", userName, "id", userID, "group", job.subGrpPath)
+		incrementUserCounter()
+		registry.addUser(job.regSubgroup, userID, userName)
+		setUserPassword(ctx, client, token, realm, userID, userName)
+		assignUserRole(ctx, client, token, realm, userID, userName)
+		notifyWebhook("user", userName, userID, realm)
+		sendActionsEmail(ctx, client, token, realm, userID, userName)
+		if config.attributes.Count > 0 {
+			readBackAttributes(ctx, client, token, realm, userName, attributeSize)
+		}
+		recordUser(userName, userStamp, job.userIdx, job.subGrpLine)
+	}
+	return nil
+}
+
+// Create a generated user with the runner, into group unless it is the zero Group, with the
+// -required-actions. The failure is logged and counted before it is returned, unless it is
+// keycloakload.ErrStopped.
+func createGeneratedUser(ctx context.Context, realm string, generated keycloakload.User, group keycloakload.Group) (keycloakload.Created, error) {
+	generated.RequiredActions = config.requiredActions
+	created, err := runner.CreateUser(ctx, realm, generated, group)
+	if errors.Is(err, keycloakload.ErrStopped) {
+		return created, err
+	}
+	if err != nil {
+		slog.Error("Failed to create user", "user", created.Name, "err", err)
+		updateOperationErrorMetrics(opCreateUser, keycloakload.StatusFromError(err))
+		recordFailure(opCreateUser, created.Name, err)
+		return created, fmt.Errorf("user %s: %w", created.Name, err)
+	}
+	return created, nil
+}
+
+// Count, keep, announce and record a user created outside any group
+func recordUngroupedUser(realm, userID, userName string, stamp int64, userIdx int) {
+	slog.Debug("Created user", "user", userName, "id", userID)
+	incrementUserCounter()
+	registry.addUngroupedUser(userID, userName)
+	notifyWebhook("user", userName, userID, realm)
+	recordUser(userName, stamp, userIdx, 0)
+}
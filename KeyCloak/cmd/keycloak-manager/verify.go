@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Check that a group ended up with the expected number of subgroups, logging and counting any shortfall
+func verifySubgroupCount(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, groupID, groupName string, expected int) {
+	count, err := countSubgroups(ctx, client, token, realm, groupID, expected)
+	if err != nil {
+		slog.Error("Failed to verify subgroups", "group", groupName, "err", err)
+		updateErrorMetrics(keycloakload.StatusFromError(err))
+		recordFailure(opVerifyGroup, groupName, err)
+		return
+	}
+	if count < expected {
+		slog.Warn("Group is missing subgroups", "group", groupName, "subgroups", count, "expected", expected)
+		incrementSubgroupShortfallCounter(expected - count)
+		return
+	}
+	slog.Debug("Verified group subgroups", "group", groupName, "subgroups", expected)
+}
+
+// Count the subgroups of a group, up to max. Keycloak 23 and later no longer embed subgroups
+// in the group representation, so those are listed through the children endpoint instead.
+func countSubgroups(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, groupID string, max int) (int, error) {
+	group, err := client.GetGroup(ctx, token.AccessToken, realm, groupID)
+	if err != nil {
+		return 0, err
+	}
+	if group.SubGroups != nil && len(*group.SubGroups) > 0 {
+		return len(*group.SubGroups), nil
+	}
+
+	children, err := getChildGroups(ctx, client, token, realm, groupID, 0, max)
+	if err != nil {
+		return 0, err
+	}
+	return len(children), nil
+}
+
+// Page of the subgroups of a group from the children endpoint, which gocloak has no call for
+func getChildGroups(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm, groupID string, first, max int) ([]gocloak.Group, error) {
+	var children []gocloak.Group
+	resp, err := client.GetRequestWithBearerAuth(ctx, token.AccessToken).
+		SetResult(&children).
+		SetQueryParams(map[string]string{
+			"briefRepresentation": "true",
+			"first":               strconv.Itoa(first),
+			"max":                 strconv.Itoa(max),
+		}).
+		Get(config.url + "/admin/realms/" + realm + "/groups/" + groupID + "/children")
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, &gocloak.APIError{Code: resp.StatusCode(), Message: resp.String()}
+	}
+	return children, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"keycloak-manager/keycloakload"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Read back every group, subgroup and user recorded in an -output file and check that it still
+// exists, that users are enabled and members of the subgroup they were created in.
+// Returns an error if anything drifted.
+func verifyCreated(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var recorded Registry
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if recorded.Realm != "" && recorded.Realm != realm {
+		return fmt.Errorf("%s was recorded in realm %s, not %s", path, recorded.Realm, realm)
+	}
+
+	// Requests in flight finish after a shutdown signal, which is only checked between groups
+	shutdown, ctx := ctx, context.WithoutCancel(ctx)
+
+	total := 0
+	for _, group := range recorded.Groups {
+		total++
+		for _, subGroup := range group.SubGroups {
+			total += recordedEntities(subGroup)
+		}
+	}
+	startProgress(total)
+
+	var assertion specAssertion
+	for _, group := range recorded.Groups {
+		if shutdown.Err() != nil {
+			break
+		}
+		groupRealm := realm
+		if group.Realm != "" {
+			groupRealm = group.Realm
+		}
+		token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+		verifyGroup(ctx, client, token, groupRealm, &assertion, group.ID, keycloakload.GroupPath(group.Name))
+
+		for _, subGroup := range group.SubGroups {
+			token, expirationTime = verifySubgroup(ctx, client, token, groupRealm, expirationTime, &assertion, keycloakload.GroupPath(group.Name), subGroup)
+		}
+	}
+	return assertion.report("Verification", path)
+}
+
+// Number of subgroups and users recorded in a subgroup and below it
+func recordedEntities(subGroup *RegistrySubgroup) int {
+	entities := 1 + len(subGroup.Users)
+	for _, child := range subGroup.SubGroups {
+		entities += recordedEntities(child)
+	}
+	return entities
+}
+
+func verifySubgroup(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, expirationTime time.Time, assertion *specAssertion, parentPath string, subGroup *RegistrySubgroup) (*gocloak.JWT, time.Time) {
+	path := parentPath + keycloakload.GroupPath(subGroup.Name)
+	token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+	verifyGroup(ctx, client, token, realm, assertion, subGroup.ID, path)
+
+	for _, child := range subGroup.SubGroups {
+		token, expirationTime = verifySubgroup(ctx, client, token, realm, expirationTime, assertion, path, child)
+	}
+	for _, user := range subGroup.Users {
+		token, expirationTime = ensureValidToken(ctx, client, token, expirationTime)
+		verifyUser(ctx, client, token, realm, assertion, user, path)
+	}
+	return token, expirationTime
+}
+
+func verifyGroup(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, assertion *specAssertion, groupID, path string) {
+	defer advanceProgress()
+	assertion.checked++
+
+	group, err := client.GetGroup(ctx, token.AccessToken, realm, groupID)
+	switch {
+	case keycloakload.IsNotFound(err):
+		assertion.fail("group %s (ID: %s) missing", path, groupID)
+	case err != nil:
+		assertion.fail("group %s: failed to get: %v", path, err)
+	case group.Path != nil && *group.Path != path:
+		assertion.fail("group %s (ID: %s) moved to %s", path, groupID, *group.Path)
+	}
+}
+
+func verifyUser(ctx context.Context, client *gocloak.GoCloak, token *gocloak.JWT, realm string, assertion *specAssertion, recorded RegistryUser, subGrpPath string) {
+	defer advanceProgress()
+	assertion.checked++
+	entity := "user " + recorded.Name
+
+	user, err := client.GetUserByID(ctx, token.AccessToken, realm, recorded.ID)
+	if keycloakload.IsNotFound(err) {
+		assertion.fail("%s (ID: %s) missing", entity, recorded.ID)
+		return
+	}
+	if err != nil {
+		assertion.fail("%s: failed to get: %v", entity, err)
+		return
+	}
+	if user.Enabled == nil || !*user.Enabled {
+		assertion.fail("%s is disabled", entity)
+	}
+
+	groups, err := client.GetUserGroups(ctx, token.AccessToken, realm, recorded.ID, gocloak.GetGroupsParams{})
+	if err != nil {
+		assertion.fail("%s: failed to get groups: %v", entity, err)
+		return
+	}
+	var paths []string
+	for _, group := range groups {
+		if group.Path != nil {
+			paths = append(paths, *group.Path)
+		}
+	}
+	if !slices.Contains(paths, subGrpPath) {
+		assertion.fail("%s: not a member of %s", entity, subGrpPath)
+	}
+}
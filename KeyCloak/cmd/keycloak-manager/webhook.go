@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// Payload POSTed to -webhook-url after every successful creation
+type WebhookEvent struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	ID    string `json:"id"`
+	Realm string `json:"realm"`
+}
+
+var webhookQueue chan WebhookEvent
+
+// Start delivering webhook events in the background through a bounded queue
+func startWebhook(url string, queueSize int) {
+	webhookQueue = make(chan WebhookEvent, queueSize)
+	restyClient := resty.New().SetTimeout(webhookTimeout)
+
+	go func() {
+		for event := range webhookQueue {
+			resp, err := restyClient.R().SetBody(event).Post(url)
+			if err == nil && resp.IsError() {
+				err = fmt.Errorf("webhook returned %s", resp.Status())
+			}
+			if err != nil {
+				slog.Error("Failed to deliver webhook", "type", event.Type, "name", event.Name, "err", err)
+				incrementWebhookFailureCounter()
+			}
+		}
+	}()
+}
+
+// Queue a webhook event without ever blocking creation; events are dropped when the queue is full
+func notifyWebhook(entityType, name, id, realm string) {
+	if webhookQueue == nil {
+		return
+	}
+
+	select {
+	case webhookQueue <- WebhookEvent{Type: entityType, Name: name, ID: id, Realm: realm}:
+	default:
+		slog.Warn("Webhook queue full, dropping event", "type", entityType, "name", name)
+		incrementWebhookFailureCounter()
+	}
+}
@@ -0,0 +1,41 @@
+package keycloakload
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// HTTP status code of a failed gocloak call, or 0 if the request didn't get a response
+func StatusFromError(err error) int {
+	var apiErr *gocloak.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return 0
+}
+
+// Whether err is Keycloak rejecting a duplicate
+func IsConflict(err error) bool {
+	var apiErr *gocloak.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict
+}
+
+// Whether err is Keycloak rejecting the token itself, expired or revoked
+func IsUnauthorized(err error) bool {
+	var apiErr *gocloak.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized
+}
+
+// Whether err is Keycloak refusing an operation the token isn't permitted to perform
+func IsForbidden(err error) bool {
+	var apiErr *gocloak.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden
+}
+
+// Whether err is Keycloak reporting that the entity doesn't exist
+func IsNotFound(err error) bool {
+	var apiErr *gocloak.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
@@ -0,0 +1,399 @@
+package keycloakload
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Kinds of data given to generated users
+const (
+	// Prefix-User-<stamp>-<index> names and nothing else
+	UserDataStamp = "stamp"
+	// Names, e-mail addresses and attributes drawn from the name lists of the locales
+	UserDataRealistic = "realistic"
+)
+
+var UserDataKinds = []string{UserDataStamp, UserDataRealistic}
+
+// Username and e-mail address of realistic users without a template of their own
+const (
+	realisticUsername = "{{.Prefix}}{{.First}}.{{.Last}}.{{.EmployeeID}}"
+	realisticEmail    = "{{.First}}.{{.Last}}.{{.EmployeeID}}@{{.Domain}}"
+)
+
+// First and last names by locale, ASCII only so that they make valid e-mail addresses
+var localeNames = map[string]struct{ first, last []string }{
+	"en": {
+		first: []string{"James", "Mary", "John", "Patricia", "Robert", "Jennifer", "Michael", "Linda", "William", "Elizabeth", "David", "Susan"},
+		last:  []string{"Smith", "Johnson", "Williams", "Brown", "Jones", "Miller", "Davis", "Wilson", "Anderson", "Taylor", "Thomas", "Moore"},
+	},
+	"de": {
+		first: []string{"Lukas", "Anna", "Felix", "Lena", "Jonas", "Laura", "Paul", "Julia", "Maximilian", "Sophie", "Leon", "Hannah"},
+		last:  []string{"Mueller", "Schmidt", "Schneider", "Fischer", "Weber", "Meyer", "Wagner", "Becker", "Schulz", "Hoffmann", "Koch", "Richter"},
+	},
+	"fr": {
+		first: []string{"Gabriel", "Emma", "Louis", "Jade", "Raphael", "Louise", "Arthur", "Alice", "Jules", "Chloe", "Hugo", "Lea"},
+		last:  []string{"Martin", "Bernard", "Dubois", "Thomas", "Robert", "Richard", "Petit", "Durand", "Leroy", "Moreau", "Simon", "Laurent"},
+	},
+	"es": {
+		first: []string{"Hugo", "Lucia", "Mateo", "Sofia", "Martin", "Martina", "Lucas", "Maria", "Leo", "Julia", "Daniel", "Paula"},
+		last:  []string{"Garcia", "Rodriguez", "Gonzalez", "Fernandez", "Lopez", "Martinez", "Sanchez", "Perez", "Gomez", "Martin", "Jimenez", "Ruiz"},
+	},
+}
+
+var departments = []string{"Engineering", "Sales", "Marketing", "Finance", "Human Resources", "Support", "Operations", "Legal"}
+
+// Locales with name lists
+func Locales() []string {
+	locales := make([]string, 0, len(localeNames))
+	for locale := range localeNames {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Attribute marking the users a Generator generated, so that they can be found whatever their
+// names. Its value is GeneratedMarker of the prefix they were generated under.
+const GeneratedAttribute = "keycloakLoadGenerated"
+
+// Value of GeneratedAttribute for users generated under prefix, never empty so that realms keep it
+func GeneratedMarker(prefix string) string {
+	return "prefix=" + prefix
+}
+
+// Name of a generated top-level group, its subgroups are named after it
+func GroupName(prefix string, stamp int64) string {
+	return fmt.Sprintf("%sGroup-%d", prefix, stamp)
+}
+
+func SubgroupName(groupName string, subGrpIdx int) string {
+	return fmt.Sprintf("%s-subgroup-%d", groupName, subGrpIdx)
+}
+
+// Name of a stamp-named user, the index tells the users of a subgroup apart
+func StampUsername(prefix string, stamp int64, userIdx int) string {
+	return fmt.Sprintf("%sUser-%d-%d", prefix, stamp, userIdx)
+}
+
+// What a Generator produces
+type GeneratorConfig struct {
+	// Prefix of generated names, to tell test data apart in the admin console
+	Prefix string
+	// UserDataStamp, the default, or UserDataRealistic
+	Kind string
+	// text/template strings over the fields of UserData, by default the ones of Kind
+	UsernameTemplate string
+	EmailTemplate    string
+	// Locales realistic names are drawn from, default en
+	Locales []string
+	// Domains of the e-mail addresses, picked at random, default example.com
+	EmailDomains []string
+	// Give stamp-named users an e-mail address too, e.g. for actions e-mails
+	StampEmails bool
+	// Seed of the random choices, 0 for a random one
+	Seed int64
+	// Custom attributes given to every user, to stress Keycloak's user storage
+	Attributes AttributeConfig
+}
+
+// Count and size of the custom attributes of generated users. Keys are attr<index> padded to
+// KeySize, values are random letters and digits of a size in [MinValueSize, MaxValueSize].
+type AttributeConfig struct {
+	Count        int
+	KeySize      int
+	MinValueSize int
+	MaxValueSize int
+	// Values per attribute, more than one makes them multi-valued, default 1
+	Values int
+}
+
+// Characters of generated attribute values
+const attributeAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// Fields of a generated user, also what the templates can refer to
+type UserData struct {
+	Prefix string
+	Stamp  int64
+	Index  int
+	Domain string
+	// Only set for realistic users
+	First      string
+	Last       string
+	Locale     string
+	Department string
+	EmployeeID string
+}
+
+// A user as generated, before a name collision may change its name
+type User struct {
+	Username   string
+	Email      string
+	FirstName  string
+	LastName   string
+	Attributes map[string][]string
+	// Actions the user must complete on first login, e.g. UPDATE_PASSWORD
+	RequiredActions []string
+}
+
+// Produces the data of created users, safe for concurrent use
+type Generator struct {
+	config   GeneratorConfig
+	username *template.Template
+	email    *template.Template
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func NewGenerator(config GeneratorConfig) (*Generator, error) {
+	if config.Kind == "" {
+		config.Kind = UserDataStamp
+	}
+	if !slices.Contains(UserDataKinds, config.Kind) {
+		return nil, fmt.Errorf("unknown user data %q, must be one of %v", config.Kind, UserDataKinds)
+	}
+	if len(config.Locales) == 0 {
+		config.Locales = []string{"en"}
+	}
+	for _, locale := range config.Locales {
+		if _, ok := localeNames[locale]; !ok {
+			return nil, fmt.Errorf("unknown locale %q, must be one of %v", locale, Locales())
+		}
+	}
+	if len(config.EmailDomains) == 0 {
+		config.EmailDomains = []string{"example.com"}
+	}
+	if err := config.Attributes.validate(); err != nil {
+		return nil, err
+	}
+	config.Attributes.Values = max(config.Attributes.Values, 1)
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	g := &Generator{config: config, rand: rand.New(rand.NewSource(seed))}
+
+	usernameTemplate, emailTemplate := config.UsernameTemplate, config.EmailTemplate
+	if config.Kind == UserDataRealistic {
+		usernameTemplate = cmpOr(usernameTemplate, realisticUsername)
+		emailTemplate = cmpOr(emailTemplate, realisticEmail)
+	}
+	var err error
+	if usernameTemplate != "" {
+		if g.username, err = ParseTemplate("username", usernameTemplate); err != nil {
+			return nil, err
+		}
+	}
+	if emailTemplate != "" {
+		if g.email, err = ParseTemplate("email", emailTemplate); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+func (c AttributeConfig) validate() error {
+	switch {
+	case c.Count < 0 || c.Values < 0:
+		return fmt.Errorf("attribute count and values per attribute must not be negative")
+	case c.Count == 0:
+		return nil
+	case c.KeySize < len(attributeKey(c.Count-1, 0)):
+		return fmt.Errorf("attribute key size %d is too small for %d attributes, must be at least %d", c.KeySize, c.Count, len(attributeKey(c.Count-1, 0)))
+	case c.MinValueSize < 1 || c.MaxValueSize < c.MinValueSize:
+		return fmt.Errorf("attribute value size %d-%d must be a range of at least 1", c.MinValueSize, c.MaxValueSize)
+	}
+	return nil
+}
+
+func attributeKey(attrIdx, keySize int) string {
+	key := fmt.Sprintf("attr%d", attrIdx)
+	return key + strings.Repeat("_", max(keySize-len(key), 0))
+}
+
+// Must be called with mu held
+func (g *Generator) attributeValue() string {
+	attributes := g.config.Attributes
+	value := make([]byte, attributes.MinValueSize+g.rand.Intn(attributes.MaxValueSize-attributes.MinValueSize+1))
+	for i := range value {
+		value[i] = attributeAlphabet[g.rand.Intn(len(attributeAlphabet))]
+	}
+	return string(value)
+}
+
+func (g *Generator) customAttributes() map[string][]string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	attributes := make(map[string][]string, g.config.Attributes.Count)
+	for attrIdx := range g.config.Attributes.Count {
+		values := make([]string, g.config.Attributes.Values)
+		for i := range values {
+			values[i] = g.attributeValue()
+		}
+		attributes[attributeKey(attrIdx, g.config.Attributes.KeySize)] = values
+	}
+	return attributes
+}
+
+// Bytes of the keys and values of attributes, the payload they add to a user
+func AttributeSize(attributes map[string][]string) int {
+	size := 0
+	for key, values := range attributes {
+		size += len(key)
+		for _, value := range values {
+			size += len(value)
+		}
+	}
+	return size
+}
+
+func cmpOr(value, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Parse a username or e-mail template, rejecting fields UserData doesn't have
+func ParseTemplate(name, value string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, UserData{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func (g *Generator) pick(values []string) string {
+	return values[g.rand.Intn(len(values))]
+}
+
+// Random first and last name of one of the locales, whatever the kind of user data
+func (g *Generator) RandomName() (string, string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	names := localeNames[g.pick(g.config.Locales)]
+	return g.pick(names.first), g.pick(names.last)
+}
+
+func (g *Generator) userData(stamp int64, userIdx int) UserData {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	data := UserData{Prefix: g.config.Prefix, Stamp: stamp, Index: userIdx, Domain: g.pick(g.config.EmailDomains)}
+	if g.config.Kind == UserDataRealistic {
+		data.Locale = g.pick(g.config.Locales)
+		names := localeNames[data.Locale]
+		data.First = g.pick(names.first)
+		data.Last = g.pick(names.last)
+		data.Department = g.pick(departments)
+		data.EmployeeID = fmt.Sprintf("%06d", g.rand.Intn(1000000))
+	}
+	return data
+}
+
+// Generate the user with the given stamp and index in its subgroup. Fails if a template fails
+// on the data, e.g. calling a function with a value it can't take.
+func (g *Generator) User(stamp int64, userIdx int) (User, error) {
+	data := g.userData(stamp, userIdx)
+	realistic := g.config.Kind == UserDataRealistic
+
+	user := User{Attributes: map[string][]string{GeneratedAttribute: {GeneratedMarker(data.Prefix)}}}
+	var err error
+	if g.username != nil {
+		if user.Username, err = execute(g.username, data); err != nil {
+			return User{}, err
+		}
+	} else {
+		user.Username = StampUsername(data.Prefix, stamp, userIdx)
+	}
+	// Keycloak stores usernames and e-mail addresses in lower case
+	if realistic {
+		user.Username = strings.ToLower(user.Username)
+	}
+
+	switch {
+	case g.email != nil:
+		if user.Email, err = execute(g.email, data); err != nil {
+			return User{}, err
+		}
+		user.Email = strings.ToLower(user.Email)
+	case g.config.StampEmails:
+		user.Email = strings.ToLower(user.Username) + "@" + data.Domain
+	}
+
+	if realistic {
+		user.FirstName = data.First
+		user.LastName = data.Last
+		user.Attributes["locale"] = []string{data.Locale}
+		user.Attributes["department"] = []string{data.Department}
+		user.Attributes["employeeID"] = []string{data.EmployeeID}
+	}
+	if g.config.Attributes.Count > 0 {
+		maps.Copy(user.Attributes, g.customAttributes())
+	}
+	return user, nil
+}
+
+func execute(tmpl *template.Template, data UserData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// The user under another name, e.g. one a mode tells its users apart by. An e-mail address made
+// from the username follows the new name.
+func (u User) Renamed(name string) User {
+	if local, domain, ok := strings.Cut(u.Email, "@"); ok && local == strings.ToLower(u.Username) {
+		u.Email = strings.ToLower(name) + "@" + domain
+	}
+	u.Username = name
+	return u
+}
+
+// Representation of the user created under name, which a name collision strategy may have
+// suffixed. The suffix goes into the e-mail address too, which realms keep unique by default.
+func (u User) Representation(name string) gocloak.User {
+	user := gocloak.User{
+		Username: &name,
+		Enabled:  gocloak.BoolP(true),
+	}
+	if u.FirstName != "" {
+		user.FirstName = &u.FirstName
+	}
+	if u.LastName != "" {
+		user.LastName = &u.LastName
+	}
+	if u.Email != "" {
+		email := u.Email
+		if suffix, ok := strings.CutPrefix(name, u.Username); ok && suffix != "" {
+			local, domain, _ := strings.Cut(email, "@")
+			email = local + suffix + "@" + domain
+		}
+		user.Email = &email
+	}
+	if u.Attributes != nil {
+		user.Attributes = &u.Attributes
+	}
+	if len(u.RequiredActions) > 0 {
+		user.RequiredActions = &u.RequiredActions
+	}
+	return user
+}
@@ -0,0 +1,173 @@
+package keycloakload
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratorStamp(t *testing.T) {
+	generator, err := NewGenerator(GeneratorConfig{Prefix: "Load-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := generator.User(42, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "Load-User-42-3" {
+		t.Errorf("Username = %q, want Load-User-42-3", user.Username)
+	}
+	if user.Email != "" || len(user.Attributes) != 1 {
+		t.Errorf("stamp user has data: %+v", user)
+	}
+	if got := user.Attributes[GeneratedAttribute]; len(got) != 1 || got[0] != "prefix=Load-" {
+		t.Errorf("%s = %v, want [prefix=Load-]", GeneratedAttribute, got)
+	}
+
+	generator, err = NewGenerator(GeneratorConfig{StampEmails: true, EmailDomains: []string{"corp.test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := generator.User(42, 3); user.Email != "user-42-3@corp.test" {
+		t.Errorf("Email = %q, want user-42-3@corp.test", user.Email)
+	}
+}
+
+func TestGeneratorRealistic(t *testing.T) {
+	generator, err := NewGenerator(GeneratorConfig{Kind: UserDataRealistic, Locales: []string{"de"}, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := generator.User(42, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != strings.ToLower(user.Username) {
+		t.Errorf("Username %q isn't lower case", user.Username)
+	}
+	if !strings.HasSuffix(user.Email, "@example.com") {
+		t.Errorf("Email = %q, want one at example.com", user.Email)
+	}
+	if user.FirstName == "" || user.LastName == "" {
+		t.Errorf("realistic user has no name: %+v", user)
+	}
+	if got := user.Attributes["locale"]; len(got) != 1 || got[0] != "de" {
+		t.Errorf("locale = %v, want [de]", got)
+	}
+
+	// The same seed generates the same users
+	again, err := NewGenerator(GeneratorConfig{Kind: UserDataRealistic, Locales: []string{"de"}, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := again.User(42, 1); other.Username != user.Username {
+		t.Errorf("seeded generators differ: %q and %q", user.Username, other.Username)
+	}
+}
+
+func TestGeneratorTemplates(t *testing.T) {
+	generator, err := NewGenerator(GeneratorConfig{
+		Prefix:           "lt-",
+		UsernameTemplate: "{{.Prefix}}u{{.Index}}",
+		EmailTemplate:    "U{{.Index}}@{{.Domain}}",
+		EmailDomains:     []string{"a.test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := generator.User(1, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "lt-u7" || user.Email != "u7@a.test" {
+		t.Errorf("User() = %q <%q>, want lt-u7 <u7@a.test>", user.Username, user.Email)
+	}
+
+	// The template passes the check on empty data, but fails on the index of this user
+	generator, err = NewGenerator(GeneratorConfig{UsernameTemplate: "{{if .Index}}{{index .Prefix 5}}{{end}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generator.User(1, 7); err == nil {
+		t.Error("User() succeeded with a failing template")
+	}
+}
+
+func TestNewGeneratorErrors(t *testing.T) {
+	for _, config := range []GeneratorConfig{
+		{Kind: "fake"},
+		{Locales: []string{"xx"}},
+		{UsernameTemplate: "{{.Missing}}"},
+		{EmailTemplate: "{{"},
+	} {
+		if _, err := NewGenerator(config); err == nil {
+			t.Errorf("NewGenerator(%+v) succeeded", config)
+		}
+	}
+}
+
+func TestUserRepresentation(t *testing.T) {
+	user := User{Username: "jane.doe", Email: "jane.doe@example.com", FirstName: "Jane"}
+
+	rep := user.Representation("jane.doe-2")
+	if *rep.Username != "jane.doe-2" || *rep.Email != "jane.doe-2@example.com" {
+		t.Errorf("Representation() = %q <%q>, want the suffix in both", *rep.Username, *rep.Email)
+	}
+	if *rep.FirstName != "Jane" || rep.LastName != nil || !*rep.Enabled {
+		t.Errorf("Representation() = %+v, want an enabled Jane without last name", rep)
+	}
+}
+
+func TestUserRenamed(t *testing.T) {
+	stamp := User{Username: "User-1-1", Email: "user-1-1@example.com"}.Renamed("Population-1-1")
+	if stamp.Username != "Population-1-1" || stamp.Email != "population-1-1@example.com" {
+		t.Errorf("Renamed() = %q <%q>, want the e-mail address to follow the name", stamp.Username, stamp.Email)
+	}
+	realistic := User{Username: "jane.doe", Email: "jane@example.com"}.Renamed("Population-1-1")
+	if realistic.Email != "jane@example.com" {
+		t.Errorf("Renamed() e-mail = %q, want an address of its own kept", realistic.Email)
+	}
+}
+
+func TestGeneratorAttributes(t *testing.T) {
+	generator, err := NewGenerator(GeneratorConfig{
+		Kind:       UserDataRealistic,
+		Attributes: AttributeConfig{Count: 12, KeySize: 10, MinValueSize: 5, MaxValueSize: 20, Values: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := generator.User(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The custom attributes come on top of the realistic ones
+	if len(user.Attributes) != 16 || user.Attributes["locale"] == nil {
+		t.Fatalf("user has %d attributes, want 12 custom, 3 realistic and the marker", len(user.Attributes))
+	}
+	values := user.Attributes["attr11____"]
+	if len(values) != 3 {
+		t.Fatalf("attr11____ = %v, want 3 values", values)
+	}
+	for _, value := range values {
+		if len(value) < 5 || len(value) > 20 {
+			t.Errorf("value %q is %d long, want 5 to 20", value, len(value))
+		}
+	}
+	if size := AttributeSize(map[string][]string{"ab": {"cde", "f"}}); size != 6 {
+		t.Errorf("AttributeSize() = %d, want 6", size)
+	}
+}
+
+func TestAttributeConfigErrors(t *testing.T) {
+	for _, attributes := range []AttributeConfig{
+		{Count: -1},
+		{Count: 100, KeySize: 5, MinValueSize: 1, MaxValueSize: 1},
+		{Count: 1, KeySize: 8, MinValueSize: 0, MaxValueSize: 1},
+		{Count: 1, KeySize: 8, MinValueSize: 10, MaxValueSize: 5},
+	} {
+		if _, err := NewGenerator(GeneratorConfig{Attributes: attributes}); err == nil {
+			t.Errorf("NewGenerator() with attributes %+v succeeded", attributes)
+		}
+	}
+}
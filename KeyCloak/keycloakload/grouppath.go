@@ -0,0 +1,37 @@
+package keycloakload
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Keycloak escapes a "/" inside a group name as "~/" so it isn't read as a path separator
+const (
+	groupPathSeparator = "/"
+	groupPathEscape    = "~"
+)
+
+// Build the Keycloak path of a group from the names of its ancestors followed by its own name,
+// as used in the Groups field of a user
+func GroupPath(names ...string) string {
+	var path strings.Builder
+	for _, name := range names {
+		path.WriteString(groupPathSeparator)
+		path.WriteString(escapeGroupName(name))
+	}
+	return path.String()
+}
+
+func escapeGroupName(name string) string {
+	return strings.ReplaceAll(name, groupPathSeparator, groupPathEscape+groupPathSeparator)
+}
+
+// Percent-encode a group path for appending to a request URL, keeping its separators intact.
+// The leading separator is dropped as the URL already ends in one.
+func GroupPathURL(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, groupPathSeparator), groupPathSeparator)
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, groupPathSeparator)
+}
@@ -0,0 +1,41 @@
+package keycloakload
+
+import "testing"
+
+func TestGroupPath(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"Group-1"}, "/Group-1"},
+		{[]string{"Group-1", "Group-1-subgroup-1"}, "/Group-1/Group-1-subgroup-1"},
+		{[]string{"Sales/EMEA"}, "/Sales~/EMEA"},
+		{[]string{"Sales/EMEA", "a/b/c"}, "/Sales~/EMEA/a~/b~/c"},
+		{[]string{"Sales Team", "New York"}, "/Sales Team/New York"},
+		{[]string{"100% Club", "50%"}, "/100% Club/50%"},
+	}
+
+	for _, tt := range tests {
+		if got := GroupPath(tt.names...); got != tt.want {
+			t.Errorf("GroupPath(%q) = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestGroupPathURL(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/Group-1/Group-1-subgroup-1", "Group-1/Group-1-subgroup-1"},
+		{"/Sales~/EMEA", "Sales~/EMEA"},
+		{"/Sales Team/New York", "Sales%20Team/New%20York"},
+		{"/100% Club/50%", "100%25%20Club/50%25"},
+	}
+
+	for _, tt := range tests {
+		if got := GroupPathURL(tt.path); got != tt.want {
+			t.Errorf("GroupPathURL(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package keycloakload
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+const testRealm = "test"
+
+// Just enough of the Keycloak token endpoint and admin API for a TokenManager and a Runner
+type mockKeycloak struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	logins    int
+	refreshes int
+	expiresIn int
+	// Access tokens the admin API rejects with 401
+	revoked map[string]bool
+	// Statuses the admin API responds with before it handles requests again
+	failures  []int
+	groups    map[string]string
+	subgroups map[string][]string
+	users     []gocloak.User
+	nextID    int
+}
+
+func newMockKeycloak(t *testing.T) *mockKeycloak {
+	m := &mockKeycloak{
+		expiresIn: 300,
+		revoked:   make(map[string]bool),
+		groups:    make(map[string]string),
+		subgroups: make(map[string][]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /realms/{realm}/protocol/openid-connect/token", m.token)
+	mux.HandleFunc("POST /admin/realms/{realm}/groups", m.authorized(m.createGroup))
+	mux.HandleFunc("POST /admin/realms/{realm}/groups/{id}/children", m.authorized(m.createSubgroup))
+	mux.HandleFunc("POST /admin/realms/{realm}/users", m.authorized(m.createUser))
+	mux.HandleFunc("GET /admin/realms/{realm}/users", m.authorized(m.getUsers))
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockKeycloak) client() *gocloak.GoCloak {
+	return gocloak.NewClient(m.server.URL)
+}
+
+func (m *mockKeycloak) token(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch r.Form.Get("grant_type") {
+	case "password":
+		if r.Form.Get("password") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		m.logins++
+	case "client_credentials":
+		m.logins++
+	case "refresh_token":
+		m.refreshes++
+	default:
+		http.Error(w, "unsupported grant", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(gocloak.JWT{
+		AccessToken:  fmt.Sprintf("access-%d-%d", m.logins, m.refreshes),
+		RefreshToken: fmt.Sprintf("refresh-%d-%d", m.logins, m.refreshes),
+		ExpiresIn:    m.expiresIn,
+	})
+}
+
+func (m *mockKeycloak) revoke(accessToken string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revoked[accessToken] = true
+}
+
+// Respond to the next admin API requests with statuses, one each
+func (m *mockKeycloak) fail(statuses ...int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures = append(m.failures, statuses...)
+}
+
+func (m *mockKeycloak) authorized(handler func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || m.revoked[accessToken] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if len(m.failures) > 0 {
+			w.WriteHeader(m.failures[0])
+			m.failures = m.failures[1:]
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Must be called with mu held
+func (m *mockKeycloak) created(w http.ResponseWriter, r *http.Request) string {
+	m.nextID++
+	id := fmt.Sprintf("id-%d", m.nextID)
+	w.Header().Set("Location", r.URL.Path+"/"+id)
+	w.WriteHeader(http.StatusCreated)
+	return id
+}
+
+func (m *mockKeycloak) createGroup(w http.ResponseWriter, r *http.Request) {
+	var group gocloak.Group
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.groups[m.created(w, r)] = *group.Name
+}
+
+func (m *mockKeycloak) createSubgroup(w http.ResponseWriter, r *http.Request) {
+	parent := r.PathValue("id")
+	if _, ok := m.groups[parent]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var group gocloak.Group
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.subgroups[parent] = append(m.subgroups[parent], *group.Name)
+	m.created(w, r)
+}
+
+func (m *mockKeycloak) createUser(w http.ResponseWriter, r *http.Request) {
+	var user gocloak.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, existing := range m.users {
+		if *existing.Username == *user.Username {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+	}
+	user.ID = gocloak.StringP(m.created(w, r))
+	m.users = append(m.users, user)
+}
+
+func (m *mockKeycloak) getUsers(w http.ResponseWriter, r *http.Request) {
+	found := []gocloak.User{}
+	for _, user := range m.users {
+		if *user.Username == r.URL.Query().Get("username") {
+			found = append(found, user)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(found)
+}
+
+// Users created so far
+func (m *mockKeycloak) createdUsers() []gocloak.User {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]gocloak.User(nil), m.users...)
+}
@@ -0,0 +1,403 @@
+package keycloakload
+
+import (
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// Latencies are recorded in nanoseconds, which is the unit HdrHistogram log tooling assumes
+const (
+	HdrLowestLatency      = int64(time.Microsecond)
+	HdrHighestLatency     = int64(24 * time.Hour)
+	HdrSignificantFigures = 3
+)
+
+// Upper bounds in seconds of the latency buckets of OperationMetrics, e.g. for Prometheus
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Upper bounds of the attribute payload buckets, in bytes
+var AttributeBuckets = []int{1 << 10, 4 << 10, 16 << 10, 64 << 10}
+
+// Histogram of latencies recorded from now on
+func NewLatencyHistogram() *hdrhistogram.Histogram {
+	histogram := hdrhistogram.New(HdrLowestLatency, HdrHighestLatency, HdrSignificantFigures)
+	histogram.SetStartTimeMs(time.Now().UnixMilli())
+	return histogram
+}
+
+func recordLatency(histogram *hdrhistogram.Histogram, latency time.Duration) {
+	if err := histogram.RecordValue(int64(latency)); err != nil {
+		slog.Warn("Latency out of histogram range", "latency", latency)
+	}
+}
+
+// Latency metrics for a single operation type or HTTP method, guarded by a lock of its owner
+type OperationMetrics struct {
+	count        int
+	errors       int
+	totalLatency time.Duration
+	peakLatency  time.Duration
+	// Count of latencies by the first of LatencyBuckets they fit in, with one more for the slowest
+	buckets []int
+	// All latencies, for percentiles
+	histogram *hdrhistogram.Histogram
+}
+
+func (m *OperationMetrics) Record(latency time.Duration) {
+	if m.buckets == nil {
+		m.buckets = make([]int, len(LatencyBuckets)+1)
+		m.histogram = hdrhistogram.New(HdrLowestLatency, HdrHighestLatency, HdrSignificantFigures)
+	}
+	recordLatency(m.histogram, latency)
+	m.buckets[sort.SearchFloat64s(LatencyBuckets, latency.Seconds())]++
+	m.count++
+	m.totalLatency += latency
+	if latency > m.peakLatency {
+		m.peakLatency = latency
+	}
+}
+
+// Count a failure, which may not have a latency if it got no response
+func (m *OperationMetrics) RecordError() {
+	m.errors++
+}
+
+func (m *OperationMetrics) Stats() OperationStats {
+	stats := OperationStats{
+		Count:        m.count,
+		Errors:       m.errors,
+		TotalLatency: m.totalLatency,
+		PeakLatency:  m.peakLatency,
+		Buckets:      slices.Clone(m.buckets),
+	}
+	if m.histogram != nil {
+		stats.P50 = time.Duration(m.histogram.ValueAtQuantile(50))
+		stats.P95 = time.Duration(m.histogram.ValueAtQuantile(95))
+		stats.P99 = time.Duration(m.histogram.ValueAtQuantile(99))
+	}
+	return stats
+}
+
+// Copy of OperationMetrics at one point
+type OperationStats struct {
+	Count        int
+	Errors       int
+	TotalLatency time.Duration
+	PeakLatency  time.Duration
+	// Count of latencies by the first of LatencyBuckets they fit in, with one more for the
+	// slowest, nil before the first latency
+	Buckets       []int
+	P50, P95, P99 time.Duration
+}
+
+// Average latency, 0 for an operation that only failed before a request was made
+func (s OperationStats) Average() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Count)
+}
+
+// An operation at a depth of the group hierarchy, with top-level groups at depth 1
+type DepthKey struct {
+	Depth int
+	Op    string
+}
+
+// An operation on users whose attributes fall into a payload bucket, an index of AttributeBuckets
+// or len(AttributeBuckets) for the payloads above the last bound
+type AttributeKey struct {
+	Bucket int
+	Op     string
+}
+
+func AttributeBucket(size int) int {
+	return sort.SearchInts(AttributeBuckets, size+1)
+}
+
+func AttributeBucketName(bucket int) string {
+	kib := func(size int) string { return strconv.Itoa(size>>10) + "KiB" }
+	switch bucket {
+	case 0:
+		return "<" + kib(AttributeBuckets[0])
+	case len(AttributeBuckets):
+		return ">=" + kib(AttributeBuckets[bucket-1])
+	}
+	return kib(AttributeBuckets[bucket-1]) + "-" + kib(AttributeBuckets[bucket])
+}
+
+// Latency from a histogram of one breakdown
+type LatencyStats struct {
+	Count    int64
+	Mean     time.Duration
+	P95, P99 time.Duration
+}
+
+func latencyStats(histogram *hdrhistogram.Histogram) LatencyStats {
+	return LatencyStats{
+		Count: histogram.TotalCount(),
+		Mean:  time.Duration(histogram.Mean()),
+		P95:   time.Duration(histogram.ValueAtQuantile(95)),
+		P99:   time.Duration(histogram.ValueAtQuantile(99)),
+	}
+}
+
+// Operations and errors of one minute of a run
+type MinuteStats struct {
+	Operations int
+	Errors     int
+}
+
+// What Metrics break latency down by
+type MetricsConfig struct {
+	// Operations counted in the totals, all of them if empty. The others still show up by operation.
+	MeasureOps []string
+	// Record create latency by the depth of the group hierarchy
+	DepthLatency bool
+	// Record create latency by the attribute payload of the user
+	AttributeLatency bool
+}
+
+// Latency and errors of the operations of a run, safe for concurrent use
+type Metrics struct {
+	config MetricsConfig
+
+	mu           sync.Mutex
+	startTime    time.Time
+	requests     int
+	totalLatency time.Duration
+	peakLatency  time.Duration
+	operations   map[string]*OperationMetrics
+	depths       map[DepthKey]*hdrhistogram.Histogram
+	attributes   map[AttributeKey]*hdrhistogram.Histogram
+	// All latencies since the last TakeInterval
+	interval *hdrhistogram.Histogram
+	// By minute since startTime
+	perMinute []MinuteStats
+
+	errors      int
+	errorCounts map[int]int
+	// 409s, the entity already exists, which aren't counted as errors
+	conflicts int
+
+	retries           int
+	collisions        int
+	forbiddenRetries  int
+	forbiddenResolved int
+}
+
+func NewMetrics(config MetricsConfig) *Metrics {
+	return &Metrics{
+		config:      config,
+		startTime:   time.Now(),
+		operations:  make(map[string]*OperationMetrics),
+		depths:      make(map[DepthKey]*hdrhistogram.Histogram),
+		attributes:  make(map[AttributeKey]*hdrhistogram.Histogram),
+		interval:    NewLatencyHistogram(),
+		errorCounts: make(map[int]int),
+	}
+}
+
+// Metrics of op, created on first use. Must be called with mu held.
+func (m *Metrics) operation(op string) *OperationMetrics {
+	opMetrics, ok := m.operations[op]
+	if !ok {
+		opMetrics = &OperationMetrics{}
+		m.operations[op] = opMetrics
+	}
+	return opMetrics
+}
+
+// Bucket of the current minute of the run. Must be called with mu held.
+func (m *Metrics) currentMinute() *MinuteStats {
+	minute := int(time.Since(m.startTime) / time.Minute)
+	for len(m.perMinute) <= minute {
+		m.perMinute = append(m.perMinute, MinuteStats{})
+	}
+	return &m.perMinute[minute]
+}
+
+func (m *Metrics) RecordLatency(op string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.operation(op).Record(latency)
+	m.currentMinute().Operations++
+
+	if len(m.config.MeasureOps) > 0 && !slices.Contains(m.config.MeasureOps, op) {
+		return
+	}
+	m.requests++
+	m.totalLatency += latency
+	if latency > m.peakLatency {
+		m.peakLatency = latency
+	}
+	recordLatency(m.interval, latency)
+}
+
+// Count a failed operation by its HTTP status code, 0 for no response, and also against op unless
+// it is "". A 409 is only counted as a conflict, not as an error.
+func (m *Metrics) RecordError(op string, statusCode int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if op != "" {
+		m.operation(op).RecordError()
+	}
+	if statusCode == http.StatusConflict {
+		m.conflicts++
+		return
+	}
+	m.currentMinute().Errors++
+	m.errorCounts[statusCode]++
+	m.errors++
+}
+
+// Record the latency of an operation at a depth of the group hierarchy, if MetricsConfig.DepthLatency is set
+func (m *Metrics) RecordDepthLatency(depth int, op string, latency time.Duration) {
+	if !m.config.DepthLatency {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := DepthKey{Depth: depth, Op: op}
+	histogram, ok := m.depths[key]
+	if !ok {
+		histogram = hdrhistogram.New(HdrLowestLatency, HdrHighestLatency, HdrSignificantFigures)
+		m.depths[key] = histogram
+	}
+	recordLatency(histogram, latency)
+}
+
+// Record the latency of an operation on a user with size bytes of attributes, if
+// MetricsConfig.AttributeLatency is set
+func (m *Metrics) RecordAttributeLatency(size int, op string, latency time.Duration) {
+	if !m.config.AttributeLatency {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := AttributeKey{Bucket: AttributeBucket(size), Op: op}
+	histogram, ok := m.attributes[key]
+	if !ok {
+		histogram = hdrhistogram.New(HdrLowestLatency, HdrHighestLatency, HdrSignificantFigures)
+		m.attributes[key] = histogram
+	}
+	recordLatency(histogram, latency)
+}
+
+func (m *Metrics) recordRetry() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries++
+}
+
+func (m *Metrics) recordCollision() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collisions++
+}
+
+func (m *Metrics) recordForbiddenRetry(resolved bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forbiddenRetries++
+	if resolved {
+		m.forbiddenResolved++
+	}
+}
+
+// Operations counted in the totals and the errors among all of them, e.g. for an error rate
+func (m *Metrics) Counts() (requests, errors int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requests, m.errors
+}
+
+// The latencies of the totals recorded since the last call, for an HdrHistogram interval log
+func (m *Metrics) TakeInterval() *hdrhistogram.Histogram {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	histogram := m.interval
+	histogram.SetEndTimeMs(time.Now().UnixMilli())
+	m.interval = NewLatencyHistogram()
+	return histogram
+}
+
+// Copy of the Metrics at one point
+type MetricsSnapshot struct {
+	StartTime time.Time
+	// Operations counted in the totals, see MetricsConfig.MeasureOps
+	Requests     int
+	TotalLatency time.Duration
+	PeakLatency  time.Duration
+
+	// Failed operations, without conflicts, and their count by HTTP status code, 0 for no response
+	Errors      int
+	ErrorCounts map[int]int
+	Conflicts   int
+
+	// Transient failures retried, names that already existed, and 403s retried with a fresh
+	// token, of which resolved succeeded
+	Retries           int
+	Collisions        int
+	ForbiddenRetries  int
+	ForbiddenResolved int
+
+	Operations map[string]OperationStats
+	Depths     map[DepthKey]LatencyStats
+	Attributes map[AttributeKey]LatencyStats
+	PerMinute  []MinuteStats
+}
+
+// Average latency of the operations counted in the totals
+func (s MetricsSnapshot) AverageLatency() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Requests)
+}
+
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := MetricsSnapshot{
+		StartTime:         m.startTime,
+		Requests:          m.requests,
+		TotalLatency:      m.totalLatency,
+		PeakLatency:       m.peakLatency,
+		Errors:            m.errors,
+		ErrorCounts:       maps.Clone(m.errorCounts),
+		Conflicts:         m.conflicts,
+		Retries:           m.retries,
+		Collisions:        m.collisions,
+		ForbiddenRetries:  m.forbiddenRetries,
+		ForbiddenResolved: m.forbiddenResolved,
+		Operations:        make(map[string]OperationStats, len(m.operations)),
+		Depths:            make(map[DepthKey]LatencyStats, len(m.depths)),
+		Attributes:        make(map[AttributeKey]LatencyStats, len(m.attributes)),
+		PerMinute:         slices.Clone(m.perMinute),
+	}
+	for op, opMetrics := range m.operations {
+		snapshot.Operations[op] = opMetrics.Stats()
+	}
+	for key, histogram := range m.depths {
+		snapshot.Depths[key] = latencyStats(histogram)
+	}
+	for key, histogram := range m.attributes {
+		snapshot.Attributes[key] = latencyStats(histogram)
+	}
+	return snapshot
+}
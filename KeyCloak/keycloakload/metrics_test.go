@@ -0,0 +1,102 @@
+package keycloakload
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAttributeBucket(t *testing.T) {
+	tests := []struct {
+		size int
+		want string
+	}{
+		{0, "<1KiB"},
+		{1023, "<1KiB"},
+		{1024, "1KiB-4KiB"},
+		{4095, "1KiB-4KiB"},
+		{16 << 10, "16KiB-64KiB"},
+		{64 << 10, ">=64KiB"},
+		{1 << 20, ">=64KiB"},
+	}
+
+	for _, tt := range tests {
+		if got := AttributeBucketName(AttributeBucket(tt.size)); got != tt.want {
+			t.Errorf("bucket of %d bytes = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsMeasureOps(t *testing.T) {
+	metrics := NewMetrics(MetricsConfig{MeasureOps: []string{OpCreateUser}})
+	metrics.RecordLatency(OpCreateUser, 10*time.Millisecond)
+	metrics.RecordLatency("get_users", 20*time.Millisecond)
+
+	snapshot := metrics.Snapshot()
+	if snapshot.Requests != 1 || snapshot.PeakLatency != 10*time.Millisecond {
+		t.Errorf("totals = %d requests, %v peak, want only the create", snapshot.Requests, snapshot.PeakLatency)
+	}
+	// Left out of the totals, but still broken down by operation
+	if got := snapshot.Operations["get_users"].Count; got != 1 {
+		t.Errorf("get_users count = %d, want 1", got)
+	}
+	if got := snapshot.Operations[OpCreateUser].Average(); got != 10*time.Millisecond {
+		t.Errorf("create_user average = %v, want 10ms", got)
+	}
+
+	if got := metrics.TakeInterval().TotalCount(); got != 1 {
+		t.Errorf("interval count = %d, want 1", got)
+	}
+	if got := metrics.TakeInterval().TotalCount(); got != 0 {
+		t.Errorf("interval count after taking it = %d, want 0", got)
+	}
+}
+
+func TestMetricsRecordError(t *testing.T) {
+	metrics := NewMetrics(MetricsConfig{})
+	metrics.RecordLatency(OpCreateUser, time.Millisecond)
+	metrics.RecordError(OpCreateUser, http.StatusConflict)
+	metrics.RecordError(OpCreateUser, http.StatusInternalServerError)
+	metrics.RecordError("", 0)
+
+	snapshot := metrics.Snapshot()
+	if snapshot.Conflicts != 1 || snapshot.Errors != 2 {
+		t.Errorf("%d conflicts, %d errors, want 1 and 2", snapshot.Conflicts, snapshot.Errors)
+	}
+	if snapshot.ErrorCounts[http.StatusInternalServerError] != 1 || snapshot.ErrorCounts[0] != 1 {
+		t.Errorf("error counts = %v", snapshot.ErrorCounts)
+	}
+	if _, ok := snapshot.ErrorCounts[http.StatusConflict]; ok {
+		t.Error("conflict counted as an error")
+	}
+	if got := snapshot.Operations[OpCreateUser].Errors; got != 2 {
+		t.Errorf("create_user errors = %d, want 2", got)
+	}
+	if requests, errors := metrics.Counts(); requests != 1 || errors != 2 {
+		t.Errorf("Counts() = %d, %d, want 1, 2", requests, errors)
+	}
+	if got := snapshot.PerMinute[0]; got.Operations != 1 || got.Errors != 2 {
+		t.Errorf("first minute = %+v", got)
+	}
+}
+
+func TestMetricsBreakdowns(t *testing.T) {
+	off := NewMetrics(MetricsConfig{})
+	off.RecordDepthLatency(1, OpCreateGroup, time.Millisecond)
+	off.RecordAttributeLatency(100, OpCreateUser, time.Millisecond)
+	if snapshot := off.Snapshot(); len(snapshot.Depths) != 0 || len(snapshot.Attributes) != 0 {
+		t.Errorf("breakdowns recorded without being configured: %v, %v", snapshot.Depths, snapshot.Attributes)
+	}
+
+	on := NewMetrics(MetricsConfig{DepthLatency: true, AttributeLatency: true})
+	on.RecordDepthLatency(2, OpCreateSubgroup, time.Millisecond)
+	on.RecordDepthLatency(2, OpCreateSubgroup, 3*time.Millisecond)
+	on.RecordAttributeLatency(2<<10, OpCreateUser, time.Millisecond)
+	snapshot := on.Snapshot()
+	if got := snapshot.Depths[DepthKey{Depth: 2, Op: OpCreateSubgroup}].Count; got != 2 {
+		t.Errorf("depth 2 count = %d, want 2", got)
+	}
+	if got := snapshot.Attributes[AttributeKey{Bucket: 1, Op: OpCreateUser}].Count; got != 1 {
+		t.Errorf("1KiB-4KiB count = %d, want 1", got)
+	}
+}
@@ -0,0 +1,389 @@
+package keycloakload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Operations of the creates of a Runner, as recorded in its Metrics
+const (
+	OpCreateGroup    = "create_group"
+	OpCreateSubgroup = "create_subgroup"
+	OpCreateUser     = "create_user"
+)
+
+// Strategies for handling a generated name that already exists in the realm
+const (
+	CollisionFail   = "fail"
+	CollisionSkip   = "skip"
+	CollisionSuffix = "suffix"
+	CollisionReuse  = "reuse"
+)
+
+var CollisionStrategies = []string{CollisionFail, CollisionSkip, CollisionSuffix, CollisionReuse}
+
+// Give up on suffixing after this many taken names
+const maxSuffixAttempts = 100
+
+// Backoff before the first retry, doubled for every further one up to retryMaxDelay
+const (
+	retryBaseDelay = 200 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// Returned for a call that was given up on because the run stopped, e.g. while it waited for
+// its rate slot. It isn't a failure of the call, and it isn't retried.
+var ErrStopped = fmt.Errorf("run stopped: %w", context.Canceled)
+
+// What happened to an entity created under the name collision strategy
+type Outcome int
+
+const (
+	OutcomeCreated Outcome = iota
+	OutcomeReused
+	OutcomeSkipped
+)
+
+// Result of a create: the ID of the created or reused entity and the name it ended up with
+type Created struct {
+	ID      string
+	Name    string
+	Outcome Outcome
+}
+
+// A group to create subgroups or users in, the zero Group for none
+type Group struct {
+	ID string
+	// As built by GroupPath
+	Path string
+	// Depth in the group hierarchy, 1 for top-level groups and 0 if it isn't known
+	Level int
+}
+
+// How a Runner makes its calls
+type RunnerConfig struct {
+	Client *gocloak.GoCloak
+	// Admin token of the calls, renewed when Keycloak rejects it with a 401 or 403. May be nil
+	// for a Runner that only makes anonymous calls with Retry and WaitForRate.
+	Tokens *TokenManager
+	// Where latency and errors are recorded, a new Metrics with the default config if nil
+	Metrics *Metrics
+	// Calls per second over all users of the Runner that go through WaitForRate, 0 for no limit
+	Rate float64
+	// Retries of a transient failure, with exponential backoff
+	MaxRetries int
+	// Leave the jitter out of the backoff, so that runs are reproducible
+	NoJitter bool
+	// One of CollisionStrategies, CollisionFail by default
+	NameCollisionStrategy string
+	// Closed when the run stops. Ends rate waits even in calls whose context carries on so that
+	// requests in flight can finish.
+	Stop <-chan struct{}
+}
+
+// Creates groups, subgroups and users within a rate limit, retrying transient failures and
+// resolving name collisions, and records the latency of every call in its Metrics. Safe for
+// concurrent use.
+type Runner struct {
+	config  RunnerConfig
+	metrics *Metrics
+
+	// Next free slot for a call under the rate limit
+	rateMu   sync.Mutex
+	nextSlot time.Time
+}
+
+func NewRunner(config RunnerConfig) (*Runner, error) {
+	if config.NameCollisionStrategy == "" {
+		config.NameCollisionStrategy = CollisionFail
+	}
+	if !slices.Contains(CollisionStrategies, config.NameCollisionStrategy) {
+		return nil, fmt.Errorf("unknown name collision strategy %q, must be one of %v", config.NameCollisionStrategy, CollisionStrategies)
+	}
+	if config.Rate < 0 || config.MaxRetries < 0 {
+		return nil, fmt.Errorf("rate and retries must not be negative")
+	}
+	metrics := config.Metrics
+	if metrics == nil {
+		metrics = NewMetrics(MetricsConfig{})
+	}
+	return &Runner{config: config, metrics: metrics}, nil
+}
+
+func (r *Runner) Metrics() *Metrics {
+	return r.metrics
+}
+
+// Wait until the next call fits into the rate. Gives up with ErrStopped when ctx is done or the
+// run stops, since at a low rate the slot may be far off.
+func (r *Runner) WaitForRate(ctx context.Context) error {
+	if r.config.Rate <= 0 {
+		return nil
+	}
+	interval := time.Duration(float64(time.Second) / r.config.Rate)
+
+	r.rateMu.Lock()
+	slot := r.nextSlot
+	if now := time.Now(); slot.Before(now) {
+		slot = now
+	}
+	r.nextSlot = slot.Add(interval)
+	r.rateMu.Unlock()
+
+	timer := time.NewTimer(time.Until(slot))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ErrStopped
+	case <-r.config.Stop:
+		return ErrStopped
+	}
+}
+
+// Run call, retrying transient failures up to MaxRetries times with exponential backoff.
+// Only the error of the last attempt is returned, so error metrics count each operation once.
+func (r *Runner) Retry(ctx context.Context, op string, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if err == nil || attempt >= r.config.MaxRetries || !isRetryable(err) {
+			return err
+		}
+
+		delay := r.retryDelay(attempt)
+		slog.Warn("Retrying", "op", op, "delay", delay, "err", err)
+		r.metrics.recordRetry()
+		// A ready timer would win the select half the time
+		if ctx.Err() != nil {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// Whether err is worth retrying: no response at all, including timeouts, rate limiting, or an
+// overloaded or restarting server behind a proxy. Anything else, like a 400, a 409 conflict or
+// a 500 from a Keycloak bug, fails the same way again.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	switch StatusFromError(err) {
+	case 0, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Backoff before retry number attempt+1. The jitter keeps workers that failed together from
+// retrying together.
+func (r *Runner) retryDelay(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 16 {
+		delay = min(retryBaseDelay<<attempt, retryMaxDelay)
+	}
+	if r.config.NoJitter {
+		return delay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// Run call with the admin token as operation op: within the rate, with Retry, and once more with
+// a fresh token if Keycloak refuses it with a 401 or a 403. The latency of every attempt is
+// recorded against op.
+func (r *Runner) Do(ctx context.Context, op string, call func(token *gocloak.JWT) error) error {
+	return r.do(ctx, op, call, nil)
+}
+
+// Do, also handing the latency of every attempt to observe unless it is nil.
+// A 401 means the token expired or its session was ended on the server. Roles granted during
+// the run only reach the token claims on refresh, so a 403 that survives the fresh token is
+// genuine and returned as it is.
+func (r *Runner) do(ctx context.Context, op string, call func(token *gocloak.JWT) error, observe func(time.Duration)) error {
+	attempt := func(token *gocloak.JWT) error {
+		return r.Retry(ctx, op, func() error {
+			if err := r.WaitForRate(ctx); err != nil {
+				return err
+			}
+			startTime := time.Now()
+			err := call(token)
+			latency := time.Since(startTime)
+
+			r.metrics.RecordLatency(op, latency)
+			if observe != nil {
+				observe(latency)
+			}
+			return err
+		})
+	}
+
+	token, _, err := r.config.Tokens.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a token: %w", err)
+	}
+	err = attempt(token)
+	if IsUnauthorized(err) {
+		slog.Warn("Unauthorized, retrying once with a fresh token", "err", err)
+		if token, _, err = r.config.Tokens.Renew(ctx, token); err != nil {
+			return fmt.Errorf("failed to reauthenticate: %w", err)
+		}
+		err = attempt(token)
+	}
+	if !IsForbidden(err) {
+		return err
+	}
+
+	slog.Warn("Forbidden, retrying once with a fresh token", "err", err)
+	if token, _, err = r.config.Tokens.Renew(ctx, token); err != nil {
+		return fmt.Errorf("failed to reauthenticate: %w", err)
+	}
+	err = attempt(token)
+	r.metrics.recordForbiddenRetry(err == nil)
+	return err
+}
+
+// Create a named entity, resolving a 409 conflict with the name collision strategy
+func (r *Runner) create(kind, name string, create func(name string) (string, error), lookup func(name string) (string, error)) (Created, error) {
+	id, err := create(name)
+	if !IsConflict(err) {
+		return Created{ID: id, Name: name}, err
+	}
+	r.metrics.recordCollision()
+
+	switch r.config.NameCollisionStrategy {
+	case CollisionSkip:
+		slog.Info("Already exists, skipping", "kind", kind, "name", name)
+		return Created{Name: name, Outcome: OutcomeSkipped}, nil
+
+	case CollisionReuse:
+		id, err := lookup(name)
+		if err != nil {
+			return Created{Name: name, Outcome: OutcomeReused}, fmt.Errorf("failed to look up existing %s %s: %w", kind, name, err)
+		}
+		return Created{ID: id, Name: name, Outcome: OutcomeReused}, nil
+
+	case CollisionSuffix:
+		for attempt := 2; attempt <= maxSuffixAttempts; attempt++ {
+			suffixed := fmt.Sprintf("%s-%d", name, attempt)
+			id, err := create(suffixed)
+			if !IsConflict(err) {
+				return Created{ID: id, Name: suffixed}, err
+			}
+			r.metrics.recordCollision()
+		}
+		return Created{Name: name}, fmt.Errorf("no free name for %s %s after %d attempts", kind, name, maxSuffixAttempts)
+	}
+
+	return Created{Name: name}, err
+}
+
+// Create a group named name in parent, or a top-level group for the zero parent. Its latency is
+// also recorded against its depth.
+func (r *Runner) CreateGroup(ctx context.Context, realm string, parent Group, name string) (Created, error) {
+	kind, op, level := "group", OpCreateGroup, 1
+	if parent.ID != "" {
+		kind, op, level = "subgroup", OpCreateSubgroup, parent.Level+1
+	}
+	observe := func(latency time.Duration) {
+		if parent.ID == "" || parent.Level > 0 {
+			r.metrics.RecordDepthLatency(level, op, latency)
+		}
+	}
+
+	return r.create(kind, name,
+		func(name string) (string, error) {
+			var groupID string
+			err := r.do(ctx, op, func(token *gocloak.JWT) error {
+				var err error
+				if parent.ID == "" {
+					groupID, err = r.config.Client.CreateGroup(ctx, token.AccessToken, realm, gocloak.Group{Name: &name})
+				} else {
+					groupID, err = r.config.Client.CreateChildGroup(ctx, token.AccessToken, realm, parent.ID, gocloak.Group{Name: &name})
+				}
+				return err
+			}, observe)
+			return groupID, err
+		},
+		func(name string) (string, error) {
+			return r.LookupGroupID(ctx, realm, parent.Path+GroupPath(name))
+		})
+}
+
+// Create user straight into group unless it is the zero Group. Its latency is also recorded
+// against the depth of the group and the attribute payload of the user.
+func (r *Runner) CreateUser(ctx context.Context, realm string, user User, group Group) (Created, error) {
+	attributeSize := AttributeSize(user.Attributes)
+	observe := func(latency time.Duration) {
+		if group.Level > 0 {
+			r.metrics.RecordDepthLatency(group.Level, OpCreateUser, latency)
+		}
+		r.metrics.RecordAttributeLatency(attributeSize, OpCreateUser, latency)
+	}
+
+	return r.create("user", user.Username,
+		func(name string) (string, error) {
+			representation := user.Representation(name)
+			if group.Path != "" {
+				representation.Groups = &[]string{group.Path}
+			}
+
+			var userID string
+			err := r.do(ctx, OpCreateUser, func(token *gocloak.JWT) error {
+				var err error
+				userID, err = r.config.Client.CreateUser(ctx, token.AccessToken, realm, representation)
+				return err
+			}, observe)
+			return userID, err
+		},
+		func(name string) (string, error) {
+			return r.LookupUserID(ctx, realm, name)
+		})
+}
+
+// ID of the group at path, as built by GroupPath
+func (r *Runner) LookupGroupID(ctx context.Context, realm, path string) (string, error) {
+	accessToken, err := r.config.Tokens.AccessToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	group, err := r.config.Client.GetGroupByPath(ctx, accessToken, realm, GroupPathURL(path))
+	if err != nil {
+		return "", err
+	}
+	return *group.ID, nil
+}
+
+func (r *Runner) LookupUserID(ctx context.Context, realm, userName string) (string, error) {
+	accessToken, err := r.config.Tokens.AccessToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	users, err := r.config.Client.GetUsers(ctx, accessToken, realm, gocloak.GetUsersParams{
+		Username: &userName,
+		Exact:    gocloak.BoolP(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(users) == 0 {
+		return "", fmt.Errorf("user %s not found", userName)
+	}
+	return *users[0].ID, nil
+}
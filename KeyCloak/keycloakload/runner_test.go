@@ -0,0 +1,175 @@
+package keycloakload
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestRunner(t *testing.T, keycloak *mockKeycloak, config RunnerConfig) *Runner {
+	config.Client = keycloak.client()
+	config.Tokens = NewTokenManager(PasswordGrant(keycloak.client(), "admin-cli", "master", "admin", "secret"))
+	runner, err := NewRunner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runner
+}
+
+func TestRunnerCreatesGroupTree(t *testing.T) {
+	keycloak := newMockKeycloak(t)
+	runner := newTestRunner(t, keycloak, RunnerConfig{
+		Metrics: NewMetrics(MetricsConfig{DepthLatency: true}),
+	})
+	ctx := context.Background()
+
+	group, err := runner.CreateGroup(ctx, testRealm, Group{}, "Group-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := Group{ID: group.ID, Path: GroupPath(group.Name), Level: 1}
+	subgroup, err := runner.CreateGroup(ctx, testRealm, parent, "Group-1-subgroup-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := Group{ID: subgroup.ID, Path: parent.Path + GroupPath(subgroup.Name), Level: 2}
+	user, err := runner.CreateUser(ctx, testRealm, User{Username: "user-1", RequiredActions: []string{"UPDATE_PASSWORD"}}, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID == "" || user.Name != "user-1" || user.Outcome != OutcomeCreated {
+		t.Errorf("created user = %+v", user)
+	}
+
+	if got := keycloak.subgroups[group.ID]; len(got) != 1 || got[0] != "Group-1-subgroup-1" {
+		t.Errorf("subgroups of Group-1 = %v", got)
+	}
+	users := keycloak.createdUsers()
+	if len(users) != 1 || (*users[0].Groups)[0] != "/Group-1/Group-1-subgroup-1" || (*users[0].RequiredActions)[0] != "UPDATE_PASSWORD" {
+		t.Errorf("created users = %+v", users)
+	}
+
+	snapshot := runner.Metrics().Snapshot()
+	for _, op := range []string{OpCreateGroup, OpCreateSubgroup, OpCreateUser} {
+		if got := snapshot.Operations[op].Count; got != 1 {
+			t.Errorf("%s count = %d, want 1", op, got)
+		}
+	}
+	for _, key := range []DepthKey{{1, OpCreateGroup}, {2, OpCreateSubgroup}, {2, OpCreateUser}} {
+		if got := snapshot.Depths[key].Count; got != 1 {
+			t.Errorf("depth %d %s count = %d, want 1", key.Depth, key.Op, got)
+		}
+	}
+}
+
+func TestRunnerNameCollision(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     Created
+		wantErr  bool
+	}{
+		{CollisionFail, Created{Name: "user-1"}, true},
+		{CollisionSkip, Created{Name: "user-1", Outcome: OutcomeSkipped}, false},
+		{CollisionSuffix, Created{ID: "id-2", Name: "user-1-2"}, false},
+		{CollisionReuse, Created{ID: "id-1", Name: "user-1", Outcome: OutcomeReused}, false},
+	}
+
+	for _, tt := range tests {
+		keycloak := newMockKeycloak(t)
+		runner := newTestRunner(t, keycloak, RunnerConfig{NameCollisionStrategy: tt.strategy})
+		ctx := context.Background()
+
+		if _, err := runner.CreateUser(ctx, testRealm, User{Username: "user-1"}, Group{}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := runner.CreateUser(ctx, testRealm, User{Username: "user-1"}, Group{})
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: second create = %+v, %v, want %+v", tt.strategy, got, err, tt.want)
+		}
+		if tt.wantErr && !IsConflict(err) {
+			t.Errorf("%s: error %v isn't a conflict", tt.strategy, err)
+		}
+		if got := runner.Metrics().Snapshot().Collisions; got != 1 {
+			t.Errorf("%s: collisions = %d, want 1", tt.strategy, got)
+		}
+	}
+}
+
+func TestRunnerRenewsRevokedToken(t *testing.T) {
+	keycloak := newMockKeycloak(t)
+	runner := newTestRunner(t, keycloak, RunnerConfig{})
+	ctx := context.Background()
+
+	accessToken, err := runner.config.Tokens.AccessToken(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keycloak.revoke(accessToken)
+
+	if _, err := runner.CreateGroup(ctx, testRealm, Group{}, "Group-1"); err != nil {
+		t.Fatalf("create with a revoked token: %v", err)
+	}
+	if keycloak.refreshes != 1 {
+		t.Errorf("refreshes = %d, want 1", keycloak.refreshes)
+	}
+}
+
+func TestRunnerRetriesTransientFailures(t *testing.T) {
+	keycloak := newMockKeycloak(t)
+	runner := newTestRunner(t, keycloak, RunnerConfig{MaxRetries: 2, NoJitter: true})
+	keycloak.fail(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+	if _, err := runner.CreateGroup(context.Background(), testRealm, Group{}, "Group-1"); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := runner.Metrics().Snapshot()
+	if snapshot.Retries != 2 || snapshot.Operations[OpCreateGroup].Count != 3 {
+		t.Errorf("%d retries of %d attempts, want 2 of 3", snapshot.Retries, snapshot.Operations[OpCreateGroup].Count)
+	}
+}
+
+func TestRunnerDoesNotRetryClientErrors(t *testing.T) {
+	keycloak := newMockKeycloak(t)
+	runner := newTestRunner(t, keycloak, RunnerConfig{MaxRetries: 2})
+	keycloak.fail(http.StatusBadRequest)
+
+	_, err := runner.CreateGroup(context.Background(), testRealm, Group{}, "Group-1")
+	if StatusFromError(err) != http.StatusBadRequest {
+		t.Fatalf("err = %v, want a 400", err)
+	}
+	if got := runner.Metrics().Snapshot().Retries; got != 0 {
+		t.Errorf("retries = %d, want 0", got)
+	}
+}
+
+func TestRunnerWaitForRateStops(t *testing.T) {
+	stop := make(chan struct{})
+	runner, err := NewRunner(RunnerConfig{Rate: 0.001, Stop: stop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// The first call gets the current slot, the next one is 1000s off
+	if err := runner.WaitForRate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(10*time.Millisecond, func() { close(stop) })
+	if err := runner.WaitForRate(ctx); !errors.Is(err, ErrStopped) {
+		t.Errorf("err = %v, want ErrStopped", err)
+	}
+}
+
+func TestNewRunnerErrors(t *testing.T) {
+	for _, config := range []RunnerConfig{
+		{NameCollisionStrategy: "rename"},
+		{Rate: -1},
+		{MaxRetries: -1},
+	} {
+		if _, err := NewRunner(config); err == nil {
+			t.Errorf("NewRunner(%+v) succeeded", config)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package keycloakload
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// How long before it expires a token is refreshed, at most half its lifetime
+const RefreshMargin = 5 * time.Minute
+
+// Pause between the expiry checks of TokenManager.Run
+const tokenCheckInterval = 10 * time.Second
+
+// Attempts of the token source before a replacement fails, so that one failed request doesn't end a run
+const tokenAttempts = 3
+
+// Backoff before the first retry of the token source, doubled for each further one.
+// A variable so that tests don't wait.
+var tokenRetryDelay = 2 * time.Second
+
+// Obtains a fresh token given the current one and its expiry, both zero for the first login
+type TokenSource func(ctx context.Context, current *gocloak.JWT, expires time.Time) (*gocloak.JWT, time.Time, error)
+
+// Log in as username with a password grant through clientID, a public client like admin-cli.
+// The token is refreshed with its refresh token, logging in again when that fails.
+func PasswordGrant(client *gocloak.GoCloak, clientID, realm, username, password string) TokenSource {
+	return func(ctx context.Context, current *gocloak.JWT, expires time.Time) (*gocloak.JWT, time.Time, error) {
+		if current != nil && current.RefreshToken != "" {
+			token, err := client.RefreshToken(ctx, current.RefreshToken, clientID, "", realm)
+			if err == nil {
+				return token, ExpiresAt(token), nil
+			}
+			slog.Debug("Refresh failed, logging in again", "user", username, "err", err)
+		}
+		token, err := client.Login(ctx, clientID, "", realm, username, password)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return token, ExpiresAt(token), nil
+	}
+}
+
+// Log in with the client credentials grant of a confidential client. Such tokens usually come
+// without a refresh token, so they are replaced by logging in again.
+func ClientCredentialsGrant(client *gocloak.GoCloak, clientID, clientSecret, realm string) TokenSource {
+	return func(ctx context.Context, current *gocloak.JWT, expires time.Time) (*gocloak.JWT, time.Time, error) {
+		if current != nil && current.RefreshToken != "" {
+			token, err := client.RefreshToken(ctx, current.RefreshToken, clientID, clientSecret, realm)
+			if err == nil {
+				return token, ExpiresAt(token), nil
+			}
+			slog.Debug("Refresh failed, logging in again", "client", clientID, "err", err)
+		}
+		token, err := client.LoginClient(ctx, clientID, clientSecret, realm)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return token, ExpiresAt(token), nil
+	}
+}
+
+// Expiry of a token that was just issued
+func ExpiresAt(token *gocloak.JWT) time.Time {
+	return time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+}
+
+// Whether a token expiring at expires is due for a refresh
+func Expiring(token *gocloak.JWT, expires time.Time) bool {
+	margin := RefreshMargin
+	if token.ExpiresIn > 0 {
+		margin = min(margin, time.Duration(token.ExpiresIn)*time.Second/2)
+	}
+	return time.Now().After(expires.Add(-margin))
+}
+
+// Token shared by concurrent workers, so that it is refreshed once for all of them and a
+// long-running worker never holds on to an expired copy
+type TokenManager struct {
+	source TokenSource
+
+	mu      sync.Mutex
+	token   *gocloak.JWT
+	expires time.Time
+}
+
+// Manager of the tokens obtained from source, which logs in on first use
+func NewTokenManager(source TokenSource) *TokenManager {
+	return &TokenManager{source: source}
+}
+
+// Adopt a token obtained elsewhere, e.g. one supplied by the user
+func (m *TokenManager) Set(token *gocloak.JWT, expires time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.token, m.expires = token, expires
+}
+
+// Current token and its expiry as they are, nil before the first login
+func (m *TokenManager) Current() (*gocloak.JWT, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.token, m.expires
+}
+
+// Current token and its expiry, logging in or refreshing first if needed. A failing source is
+// retried a few times before the error is returned.
+func (m *TokenManager) Token(ctx context.Context) (*gocloak.JWT, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token == nil || Expiring(m.token, m.expires) {
+		if err := m.replace(ctx); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	return m.token, m.expires, nil
+}
+
+// Current access token, for the Authorization header of a request
+func (m *TokenManager) AccessToken(ctx context.Context) (string, error) {
+	token, _, err := m.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// Replace a token that Keycloak rejected with a fresh one. Workers rejected with the same
+// token share one replacement.
+func (m *TokenManager) Renew(ctx context.Context, rejected *gocloak.JWT) (*gocloak.JWT, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token == rejected {
+		if err := m.replace(ctx); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	return m.token, m.expires, nil
+}
+
+// Replace the token from the source, retrying failures with backoff. Must be called with mu held.
+func (m *TokenManager) replace(ctx context.Context) error {
+	delay := tokenRetryDelay
+	for attempt := 1; ; attempt++ {
+		token, expires, err := m.source(ctx, m.token, m.expires)
+		if err == nil {
+			m.token, m.expires = token, expires
+			return nil
+		}
+		if attempt == tokenAttempts {
+			return err
+		}
+
+		slog.Warn("Token replacement failed, retrying", "attempt", attempt, "delay", delay, "err", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// Refresh the token ahead of its expiry until ctx is done, so that workers rarely wait for it.
+// A refresh that still fails after its retries is tried again at the next check and by Token.
+func (m *TokenManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(tokenCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, _, err := m.Token(ctx); err != nil {
+				slog.Warn("Background token refresh failed", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package keycloakload
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+func TestExpiring(t *testing.T) {
+	tests := []struct {
+		expiresIn int
+		left      time.Duration
+		want      bool
+	}{
+		{3600, time.Hour, false},
+		{3600, 6 * time.Minute, false},
+		{3600, 4 * time.Minute, true},
+		// Short-lived tokens are refreshed after half their lifetime
+		{60, 40 * time.Second, false},
+		{60, 20 * time.Second, true},
+		{60, -time.Second, true},
+	}
+
+	for _, tt := range tests {
+		token := &gocloak.JWT{ExpiresIn: tt.expiresIn}
+		if got := Expiring(token, time.Now().Add(tt.left)); got != tt.want {
+			t.Errorf("Expiring(expires_in=%d, %v left) = %v, want %v", tt.expiresIn, tt.left, got, tt.want)
+		}
+	}
+}
+
+func TestTokenManagerLogsInOnce(t *testing.T) {
+	keycloak := newMockKeycloak(t)
+	tokens := NewTokenManager(PasswordGrant(keycloak.client(), "admin-cli", "master", "admin", "secret"))
+
+	first, err := tokens.AccessToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tokens.AccessToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("valid token was replaced: %q, then %q", first, second)
+	}
+	if keycloak.logins != 1 {
+		t.Errorf("logins = %d, want 1", keycloak.logins)
+	}
+}
+
+func TestTokenManagerRefreshesExpiringToken(t *testing.T) {
+	keycloak := newMockKeycloak(t)
+	tokens := NewTokenManager(PasswordGrant(keycloak.client(), "admin-cli", "master", "admin", "secret"))
+	tokens.Set(&gocloak.JWT{AccessToken: "old", RefreshToken: "refresh-old", ExpiresIn: 60}, time.Now().Add(10*time.Second))
+
+	accessToken, err := tokens.AccessToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessToken == "old" {
+		t.Error("expiring token wasn't refreshed")
+	}
+	if keycloak.refreshes != 1 || keycloak.logins != 0 {
+		t.Errorf("refreshes = %d, logins = %d, want 1 refresh and no login", keycloak.refreshes, keycloak.logins)
+	}
+}
+
+func TestTokenManagerRenew(t *testing.T) {
+	keycloak := newMockKeycloak(t)
+	tokens := NewTokenManager(ClientCredentialsGrant(keycloak.client(), "loadtest", "secret", "master"))
+	ctx := context.Background()
+
+	rejected, _, err := tokens.Token(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed, _, err := tokens.Renew(ctx, rejected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed == rejected {
+		t.Fatal("rejected token wasn't renewed")
+	}
+	// A worker still holding the rejected token gets the replacement, not another one
+	again, _, err := tokens.Renew(ctx, rejected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != renewed {
+		t.Error("token was renewed twice for the same rejection")
+	}
+}
+
+// Don't wait out the backoff of the token source retries
+func noTokenRetryDelay(t *testing.T) {
+	saved := tokenRetryDelay
+	tokenRetryDelay = 0
+	t.Cleanup(func() { tokenRetryDelay = saved })
+}
+
+func TestTokenManagerRetriesSource(t *testing.T) {
+	noTokenRetryDelay(t)
+	calls := 0
+	tokens := NewTokenManager(func(ctx context.Context, current *gocloak.JWT, expires time.Time) (*gocloak.JWT, time.Time, error) {
+		calls++
+		if calls == 1 {
+			return nil, time.Time{}, errors.New("connection reset")
+		}
+		return &gocloak.JWT{AccessToken: "fresh", ExpiresIn: 300}, time.Now().Add(5 * time.Minute), nil
+	})
+
+	accessToken, err := tokens.AccessToken(context.Background())
+	if err != nil {
+		t.Fatalf("transient failure wasn't retried: %v", err)
+	}
+	if accessToken != "fresh" || calls != 2 {
+		t.Errorf("token %q after %d calls, want fresh after 2", accessToken, calls)
+	}
+}
+
+func TestTokenManagerLoginError(t *testing.T) {
+	noTokenRetryDelay(t)
+	keycloak := newMockKeycloak(t)
+	tokens := NewTokenManager(PasswordGrant(keycloak.client(), "admin-cli", "master", "admin", "wrong"))
+
+	if _, err := tokens.AccessToken(context.Background()); err == nil {
+		t.Error("login with a wrong password succeeded")
+	}
+}
+
+func TestTokenManagerConcurrentWorkersShareLogin(t *testing.T) {
+	keycloak := newMockKeycloak(t)
+	tokens := NewTokenManager(PasswordGrant(keycloak.client(), "admin-cli", "master", "admin", "secret"))
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := tokens.AccessToken(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if keycloak.logins != 1 {
+		t.Errorf("logins = %d, want one shared by all workers", keycloak.logins)
+	}
+}